@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/kvalv/template-mvp/token"
@@ -11,7 +12,18 @@ type (
 	Expression interface {
 		String() string
 	}
+	// Node is an expression that knows where it is in the source, which
+	// every expression in this package does.
+	Node interface {
+		Expression
+		Pos() token.Span
+	}
 	Program struct {
+		List
+	}
+	// List is a sequence of text and actions, e.g. the body of a block.
+	List struct {
+		token.Token
 		Exprs []Expression
 	}
 	Action struct {
@@ -28,23 +40,118 @@ type (
 	}
 	Number struct {
 		token.Token
-		Value int
+		Value int64
+	}
+	// Uint is an integer literal that is too big for an int64.
+	Uint struct {
+		token.Token
+		Value uint64
+	}
+	Float struct {
+		token.Token
+		Value float64
 	}
 	String struct {
 		token.Token
 		Value string
 	}
+	// Nil is the untyped `nil`.
+	Nil struct {
+		token.Token
+	}
 
 	Field struct {
 		token.Token
 		Expression
 		Name string
 	}
+	// Chain selects fields of Node one after another, e.g. `.A.B.C` is
+	// the fields B and C of `.A`, and `$x.Name` is the field Name of $x.
+	Chain struct {
+		token.Token
+		Node   Expression
+		Fields []*Field
+	}
+	// Index is an index expression, e.g. `.Items[0]` or `.Map["key"]`.
+	Index struct {
+		token.Token
+		Node  Expression
+		Index Expression
+	}
+	// Slice is a slice expression, e.g. `.Name[1:4]`. Low and High are nil
+	// when omitted.
+	Slice struct {
+		token.Token
+		Node      Expression
+		Low, High Expression
+	}
+	// Identifier is a bare name, which refers to a function.
+	Identifier struct {
+		token.Token
+		Name string
+	}
+	// Call calls Fn with the given arguments, e.g. `truncate .Title 40`.
+	Call struct {
+		token.Token
+		Fn   Expression
+		Args []Expression
+	}
+	// Pipeline passes the result of each command as the last argument to
+	// the next, e.g. `.Title | upper | truncate 40`.
+	Pipeline struct {
+		token.Token
+		Cmds []Expression
+	}
 
+	// Dot is the bare `.`, i.e. the current data.
+	Dot struct {
+		token.Token
+	}
+
+	// Variable is a reference to a variable, e.g. $x. The name includes
+	// the leading $, and is just "$" for the top-level data.
+	Variable struct {
+		token.Token
+		Name string
+	}
+	// Assign binds the value of Value to Var. Op is ":=" when declaring a
+	// new variable, and "=" when assigning to an existing one.
+	Assign struct {
+		token.Token
+		Op    string
+		Var   *Variable
+		Value Expression
+	}
+
+	// Cond evaluates Body if If is truthy, and Else otherwise. Else is
+	// nil when there is no {{else}}, and another *Cond for {{else if}}.
 	Cond struct {
 		token.Token
 		If   Expression
-		Body Expression
+		Body *List
+		Else Expression
+	}
+
+	// Range iterates over Pipe, evaluating Body once per element with
+	// the element as dot. Else is evaluated when there are no elements,
+	// and is nil if no {{else}} was given. Key and Value are the optional
+	// variables in `range $key, $value := pipeline`.
+	Range struct {
+		token.Token
+		Key   *Variable
+		Value *Variable
+		Pipe  Expression
+		Body  *List
+		Else  *List
+	}
+
+	// With evaluates Body with the value of Pipe as dot, if the value is
+	// truthy. Otherwise Else is evaluated, if present.
+	With struct {
+		token.Token
+		Pipe Expression
+		Body *List
+		Else *List
 	}
 
 	Prefix struct {
@@ -63,16 +170,77 @@ func (f *Field) String() string {
 	return f.Name
 }
 
+func (c *Chain) String() string {
+	var out strings.Builder
+	out.WriteString(c.Node.String())
+	for _, field := range c.Fields {
+		out.WriteString("." + field.Name)
+	}
+	return out.String()
+}
+
+func (i *Index) String() string {
+	return fmt.Sprintf("%s[%s]", i.Node, i.Index)
+}
+func (s *Slice) String() string {
+	var low, high string
+	if s.Low != nil {
+		low = s.Low.String()
+	}
+	if s.High != nil {
+		high = s.High.String()
+	}
+	return fmt.Sprintf("%s[%s:%s]", s.Node, low, high)
+}
+
+func (i *Identifier) String() string {
+	return i.Name
+}
+
+func (c *Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", c.Fn, strings.Join(args, ", "))
+}
+
+func (p *Pipeline) String() string {
+	cmds := make([]string, len(p.Cmds))
+	for i, cmd := range p.Cmds {
+		cmds[i] = cmd.String()
+	}
+	return strings.Join(cmds, " | ")
+}
+
+func (d *Dot) String() string {
+	return "."
+}
+
+func (v *Variable) String() string {
+	return v.Name
+}
+
+func (a *Assign) String() string {
+	return fmt.Sprintf("(%s %s %s)", a.Var, a.Op, a.Value)
+}
+
 func (n *Number) String() string {
 	return fmt.Sprintf("%d", n.Value)
 }
+func (u *Uint) String() string {
+	return fmt.Sprintf("%d", u.Value)
+}
+func (f *Float) String() string {
+	return strconv.FormatFloat(f.Value, 'g', -1, 64)
+}
 
 func (s *String) String() string {
 	return s.Value
 }
-func (p *Program) String() string {
+func (l *List) String() string {
 	var b strings.Builder
-	for _, e := range p.Exprs {
+	for _, e := range l.Exprs {
 		b.WriteString(e.String())
 	}
 	return b.String()
@@ -92,9 +260,27 @@ func (p *Text) String() string {
 	}
 	return p.Text
 }
+func (n *Nil) String() string {
+	return "nil"
+}
 func (b *Boolean) String() string {
 	return fmt.Sprintf("%t", b.Value)
 }
 func (c *Cond) String() string {
+	if c.Else != nil {
+		return fmt.Sprintf("if(%s) %s else %s end", c.If, c.Body, c.Else)
+	}
 	return fmt.Sprintf("if(%s) %s end", c.If, c.Body)
 }
+func (r *Range) String() string {
+	if r.Else != nil {
+		return fmt.Sprintf("range(%s) %s else %s end", r.Pipe, r.Body, r.Else)
+	}
+	return fmt.Sprintf("range(%s) %s end", r.Pipe, r.Body)
+}
+func (w *With) String() string {
+	if w.Else != nil {
+		return fmt.Sprintf("with(%s) %s else %s end", w.Pipe, w.Body, w.Else)
+	}
+	return fmt.Sprintf("with(%s) %s end", w.Pipe, w.Body)
+}
@@ -0,0 +1,99 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kvalv/template-mvp/ast"
+	"github.com/kvalv/template-mvp/token"
+)
+
+// ParseError is a syntax error in a template.
+type ParseError struct {
+	// Name is the name of the template, if it has one.
+	Name string
+	Span token.Span
+	// Excerpt is the source line the error is on, with a caret below it.
+	Excerpt string
+	// Node is what was being parsed, if it got that far.
+	Node ast.Expression
+	Err  error
+}
+
+func (e *ParseError) Error() string { return location(e.Name, e.Span) + e.Err.Error() }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ErrorList is all the syntax errors in a template, in the order they
+// appear.
+type ErrorList []*ParseError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+	}
+}
+
+// Unwrap makes the errors in the list visible to errors.Is and errors.As.
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
+
+// ExecError is an error while executing a template, e.g. a missing field.
+type ExecError struct {
+	// Name is the name of the template, if it has one.
+	Name string
+	Span token.Span
+	// Excerpt is the source line the error is on, with a caret below it.
+	Excerpt string
+	// Node is the expression that failed.
+	Node ast.Expression
+	Err  error
+}
+
+func (e *ExecError) Error() string { return location(e.Name, e.Span) + e.Err.Error() }
+func (e *ExecError) Unwrap() error { return e.Err }
+
+// location formats the position the message of an error starts with, e.g.
+// `invoice.tmpl:12:7: `.
+func location(name string, span token.Span) string {
+	if name == "" {
+		return fmt.Sprintf("%s: ", span.Start)
+	}
+	return fmt.Sprintf("%s:%s: ", name, span.Start)
+}
+
+// Excerpt returns the line of source that span starts on, and below it a
+// caret at the column where it starts:
+//
+//	Total: {{.Totl}}
+//	          ^
+func Excerpt(source string, span token.Span) string {
+	lines := strings.Split(source, "\n")
+	if span.Start.Row < 1 || span.Start.Row > len(lines) {
+		return ""
+	}
+	line := strings.TrimSuffix(lines[span.Start.Row-1], "\r")
+	var caret strings.Builder
+	for i, r := range []rune(line) {
+		if i >= span.Start.Col-1 {
+			break
+		}
+		// keep tabs, so that the caret lines up however they're shown
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return line + "\n" + caret.String()
+}
@@ -12,6 +12,18 @@ var (
 var (
 	ErrUnexpectedToken = errors.New("unexpected token")
 	ErrNoTokens        = errors.New("no tokens")
-	ErrFieldNotFound   = errors.New("Field not found")
+	ErrFieldNotFound   = errors.New("field not found")
+	ErrKeyNotFound     = errors.New("map has no entry for key")
 	ErrNilData         = errors.New("data is nil")
+	ErrNilPointer      = errors.New("nil pointer")
+	ErrUndefinedVar    = errors.New("undefined variable")
+	ErrUndefinedFunc   = errors.New("function not defined")
+	ErrMethodNotFound  = errors.New("method not found")
+	ErrNotCallable     = errors.New("can't give arguments to non-method")
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrOverflow        = errors.New("number out of range")
+	ErrOutOfRange      = errors.New("index out of range")
+	// comparing e.g. a number with a string
+	ErrIncompatibleTypes = errors.New("incompatible types for comparison")
+	ErrNotOrdered        = errors.New("values can't be ordered")
 )
@@ -0,0 +1,210 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kvalv/template-mvp/ast"
+	"github.com/kvalv/template-mvp/errors"
+	"github.com/kvalv/template-mvp/object"
+)
+
+// Numbers are ints, uints or floats. When the operands of an arithmetic
+// operator or a comparison have different types, they are promoted first:
+//   - if either is a float, both are converted to float
+//   - an int and a uint make a uint, unless the int is negative, in which
+//     case both are converted to int
+//
+// Arithmetic that overflows is an error, instead of wrapping around or, for
+// floats, becoming infinite.
+
+func evalNumberInfix(expr *ast.Infix, left, right object.Object) object.Object {
+	result, err := arithmetic(expr.Op, left, right)
+	if err != nil {
+		return errorf(expr, "%w", err)
+	}
+	return result
+}
+
+func arithmetic(op string, left, right object.Object) (object.Object, error) {
+	left, right, err := promote(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch left := left.(type) {
+	case *object.Number:
+		value, err := intArithmetic(op, left.Value, right.(*object.Number).Value)
+		return &object.Number{Value: value}, err
+	case *object.Uint:
+		value, err := uintArithmetic(op, left.Value, right.(*object.Uint).Value)
+		return &object.Uint{Value: value}, err
+	case *object.Float:
+		value, err := floatArithmetic(op, left.Value, right.(*object.Float).Value)
+		return &object.Float{Value: value}, err
+	default:
+		return nil, fmt.Errorf("unsupported types for %s: %s and %s", op, typeName(left), typeName(right))
+	}
+}
+
+func intArithmetic(op string, a, b int64) (int64, error) {
+	var c int64
+	switch op {
+	case "+":
+		c = a + b
+		if (b > 0 && c < a) || (b < 0 && c > a) {
+			return 0, overflow(a, op, b)
+		}
+	case "-":
+		c = a - b
+		if (b > 0 && c > a) || (b < 0 && c < a) {
+			return 0, overflow(a, op, b)
+		}
+	case "*":
+		c = a * b
+		if a != 0 && (c/a != b || (a == -1 && b == math.MinInt64)) {
+			return 0, overflow(a, op, b)
+		}
+	case "/", "%":
+		if b == 0 {
+			return 0, errors.ErrDivisionByZero
+		}
+		if op == "%" {
+			return a % b, nil
+		}
+		if a == math.MinInt64 && b == -1 {
+			return 0, overflow(a, op, b)
+		}
+		c = a / b
+	default:
+		return 0, fmt.Errorf("unsupported operator %s", op)
+	}
+	return c, nil
+}
+
+func uintArithmetic(op string, a, b uint64) (uint64, error) {
+	var c uint64
+	switch op {
+	case "+":
+		c = a + b
+		if c < a {
+			return 0, overflow(a, op, b)
+		}
+	case "-":
+		if b > a {
+			return 0, overflow(a, op, b)
+		}
+		c = a - b
+	case "*":
+		c = a * b
+		if a != 0 && c/a != b {
+			return 0, overflow(a, op, b)
+		}
+	case "/", "%":
+		if b == 0 {
+			return 0, errors.ErrDivisionByZero
+		}
+		if op == "%" {
+			return a % b, nil
+		}
+		c = a / b
+	default:
+		return 0, fmt.Errorf("unsupported operator %s", op)
+	}
+	return c, nil
+}
+
+func floatArithmetic(op string, a, b float64) (float64, error) {
+	var c float64
+	switch op {
+	case "+":
+		c = a + b
+	case "-":
+		c = a - b
+	case "*":
+		c = a * b
+	case "/":
+		if b == 0 {
+			return 0, errors.ErrDivisionByZero
+		}
+		c = a / b
+	default:
+		return 0, fmt.Errorf("unsupported operator %s for %s", op, object.FLOAT_OBJ)
+	}
+	if math.IsInf(c, 0) && !math.IsInf(a, 0) && !math.IsInf(b, 0) {
+		return 0, overflow(a, op, b)
+	}
+	return c, nil
+}
+
+func overflow(a any, op string, b any) error {
+	return fmt.Errorf("%w: %v %s %v", errors.ErrOverflow, a, op, b)
+}
+
+// promote converts two numbers to the same type, following the rules above.
+func promote(a, b object.Object) (object.Object, object.Object, error) {
+	switch {
+	case a.Type() == b.Type():
+		return a, b, nil
+	case a.Type() == object.FLOAT_OBJ || b.Type() == object.FLOAT_OBJ:
+		return toFloat(a), toFloat(b), nil
+	case isNegative(a) || isNegative(b):
+		x, err := toInt(a)
+		if err != nil {
+			return nil, nil, err
+		}
+		y, err := toInt(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		return x, y, nil
+	default:
+		return toUint(a), toUint(b), nil
+	}
+}
+
+func isNumeric(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Number, *object.Uint, *object.Float:
+		return true
+	default:
+		return false
+	}
+}
+
+func isNegative(obj object.Object) bool {
+	number, ok := obj.(*object.Number)
+	return ok && number.Value < 0
+}
+
+func toInt(obj object.Object) (*object.Number, error) {
+	switch obj := obj.(type) {
+	case *object.Uint:
+		if obj.Value > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: %d doesn't fit in int64", errors.ErrOverflow, obj.Value)
+		}
+		return &object.Number{Value: int64(obj.Value)}, nil
+	default:
+		return obj.(*object.Number), nil
+	}
+}
+
+// toUint converts a number that is known to be non-negative to a uint.
+func toUint(obj object.Object) *object.Uint {
+	switch obj := obj.(type) {
+	case *object.Number:
+		return &object.Uint{Value: uint64(obj.Value)}
+	default:
+		return obj.(*object.Uint)
+	}
+}
+
+func toFloat(obj object.Object) *object.Float {
+	switch obj := obj.(type) {
+	case *object.Number:
+		return &object.Float{Value: float64(obj.Value)}
+	case *object.Uint:
+		return &object.Float{Value: float64(obj.Value)}
+	default:
+		return obj.(*object.Float)
+	}
+}
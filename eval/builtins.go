@@ -0,0 +1,304 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	gotemplate "text/template"
+
+	"github.com/kvalv/template-mvp/ast"
+	"github.com/kvalv/template-mvp/errors"
+	"github.com/kvalv/template-mvp/object"
+)
+
+// builtin is a function that is available in every template, unless a
+// function with the same name is registered by the user.
+type builtin func(args []object.Object) (object.Object, error)
+
+// builtins mirror the functions predefined by text/template. `and` and `or`
+// are missing, as they need to evaluate their arguments lazily; they are
+// handled by evalLogical instead.
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		"not":      builtinNot,
+		"len":      builtinLen,
+		"index":    builtinIndex,
+		"slice":    builtinSlice,
+		"print":    builtinPrint(fmt.Sprint),
+		"println":  builtinPrint(fmt.Sprintln),
+		"printf":   builtinPrintf,
+		"eq":       builtinEq,
+		"ne":       builtinCompare(func(a, b object.Object) (bool, error) { eq, err := equal(a, b); return !eq, err }),
+		"lt":       builtinCompare(less),
+		"le":       builtinCompare(lessOrEqual),
+		"gt":       builtinCompare(func(a, b object.Object) (bool, error) { return less(b, a) }),
+		"ge":       builtinCompare(func(a, b object.Object) (bool, error) { return lessOrEqual(b, a) }),
+		"html":     builtinEscaper(gotemplate.HTMLEscaper),
+		"js":       builtinEscaper(gotemplate.JSEscaper),
+		"urlquery": builtinEscaper(gotemplate.URLQueryEscaper),
+		"call":     builtinCall,
+	}
+}
+
+// evalLogical evaluates `and` and `or`. Like in text/template, the result is
+// the first argument that decides the outcome, and the remaining arguments
+// are not evaluated.
+func evalLogical(expr *ast.Call, name string, env *object.Environment, final []object.Object) object.Object {
+	if len(expr.Args)+len(final) == 0 {
+		return errorf(expr, "wrong number of args for %s: want at least 1 got 0", name)
+	}
+	var value object.Object
+	next := func(obj object.Object) bool {
+		value = obj
+		if isError(obj) {
+			return false
+		}
+		// `and` stops at the first falsy value, `or` at the first truthy one
+		return obj.Bool() == (name == "and")
+	}
+	for _, arg := range expr.Args {
+		if !next(Eval(arg, env)) {
+			return value
+		}
+	}
+	for _, obj := range final {
+		if !next(obj) {
+			return value
+		}
+	}
+	return value
+}
+
+func builtinNot(args []object.Object) (object.Object, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("wrong number of args: want 1 got %d", len(args))
+	}
+	return object.FromGoBool(!args[0].Bool()), nil
+}
+
+func builtinLen(args []object.Object) (object.Object, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("wrong number of args: want 1 got %d", len(args))
+	}
+	value := object.ToValue(args[0])
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return &object.Number{Value: int64(value.Len())}, nil
+	default:
+		return nil, fmt.Errorf("len of type %s", args[0].Type())
+	}
+}
+
+func builtinIndex(args []object.Object) (object.Object, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("wrong number of args: want at least 1 got 0")
+	}
+	item := object.ToValue(args[0])
+	for _, arg := range args[1:] {
+		// e.g. the elements of a []any
+		for item.Kind() == reflect.Interface {
+			item = item.Elem()
+		}
+		if !item.IsValid() {
+			return nil, fmt.Errorf("index of untyped nil")
+		}
+		switch item.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array:
+			i, err := indexArg(arg, item.Len()-1)
+			if err != nil {
+				return nil, err
+			}
+			item = item.Index(i)
+		case reflect.Map:
+			key, err := object.ToArg(arg, item.Type().Key())
+			if err != nil {
+				return nil, err
+			}
+			if value := item.MapIndex(key); value.IsValid() {
+				item = value
+			} else {
+				item = reflect.Zero(item.Type().Elem())
+			}
+		default:
+			return nil, fmt.Errorf("can't index item of type %s", item.Type())
+		}
+	}
+	return object.FromValue(item), nil
+}
+
+func builtinSlice(args []object.Object) (object.Object, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("wrong number of args: want at least 1 got 0")
+	}
+	item := object.ToValue(args[0])
+	if len(args) > 4 {
+		return nil, fmt.Errorf("too many slice indexes: %d", len(args)-1)
+	}
+
+	var cap int
+	switch item.Kind() {
+	case reflect.String:
+		if len(args) == 4 {
+			return nil, fmt.Errorf("cannot 3-index slice a string")
+		}
+		cap = item.Len()
+	case reflect.Array:
+		// only an addressable array can be sliced, which it isn't when
+		// the data is passed by value, so slice a copy of it instead
+		if !item.CanAddr() {
+			array := reflect.New(item.Type()).Elem()
+			array.Set(item)
+			item = array
+		}
+		cap = item.Cap()
+	case reflect.Slice:
+		cap = item.Cap()
+	default:
+		return nil, fmt.Errorf("can't slice item of type %s", args[0].Type())
+	}
+
+	indexes := [3]int{0, item.Len()}
+	for i, arg := range args[1:] {
+		index, err := indexArg(arg, cap)
+		if err != nil {
+			return nil, err
+		}
+		indexes[i] = index
+	}
+	if indexes[0] > indexes[1] {
+		return nil, fmt.Errorf("invalid slice index: %d > %d", indexes[0], indexes[1])
+	}
+	if len(args) < 4 {
+		return object.FromValue(item.Slice(indexes[0], indexes[1])), nil
+	}
+	if indexes[1] > indexes[2] {
+		return nil, fmt.Errorf("invalid slice index: %d > %d", indexes[1], indexes[2])
+	}
+	return object.FromValue(item.Slice3(indexes[0], indexes[1], indexes[2])), nil
+}
+
+// indexArg returns the index given by arg, checking that it is within
+// [0, max].
+func indexArg(arg object.Object, max int) (int, error) {
+	var index int64
+	switch arg := arg.(type) {
+	case *object.Number:
+		index = arg.Value
+	case *object.Uint:
+		index = int64(min(arg.Value, math.MaxInt64))
+	default:
+		return 0, fmt.Errorf("cannot index slice/array with type %s", arg.Type())
+	}
+	if index < 0 || index > int64(max) {
+		return 0, fmt.Errorf("%w: %d", errors.ErrOutOfRange, index)
+	}
+	return int(index), nil
+}
+
+func builtinPrint(print func(a ...any) string) builtin {
+	return func(args []object.Object) (object.Object, error) {
+		return &object.String{Value: print(toAny(args)...)}, nil
+	}
+}
+
+func builtinPrintf(args []object.Object) (object.Object, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("wrong number of args: want at least 1 got 0")
+	}
+	format, ok := args[0].(*object.String)
+	if !ok {
+		return nil, fmt.Errorf("format must be a string, got %s", args[0].Type())
+	}
+	return &object.String{Value: fmt.Sprintf(format.Value, toAny(args[1:])...)}, nil
+}
+
+func builtinEscaper(escape func(args ...any) string) builtin {
+	return func(args []object.Object) (object.Object, error) {
+		return &object.String{Value: escape(toAny(args)...)}, nil
+	}
+}
+
+// builtinEq reports whether the first argument is equal to any of the others.
+func builtinEq(args []object.Object) (object.Object, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("missing argument for comparison")
+	}
+	for _, arg := range args[1:] {
+		if err := checkBuiltinNumbers(args[0], arg); err != nil {
+			return nil, err
+		}
+		eq, err := equal(args[0], arg)
+		if err != nil {
+			return nil, err
+		}
+		if eq {
+			return object.TRUE, nil
+		}
+	}
+	return object.FALSE, nil
+}
+
+func builtinCompare(cmp func(a, b object.Object) (bool, error)) builtin {
+	return func(args []object.Object) (object.Object, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of args: want 2 got %d", len(args))
+		}
+		if err := checkBuiltinNumbers(args[0], args[1]); err != nil {
+			return nil, err
+		}
+		ok, err := cmp(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		return object.FromGoBool(ok), nil
+	}
+}
+
+// checkBuiltinNumbers reports an error when an integer is compared with a
+// float. The comparison builtins don't allow it, to behave the same as in
+// text/template, so that templates work with both. The operators do, see
+// the promotion rules.
+func checkBuiltinNumbers(a, b object.Object) error {
+	if isNumeric(a) && isNumeric(b) && (a.Type() == object.FLOAT_OBJ) != (b.Type() == object.FLOAT_OBJ) {
+		return incompatible(a, b)
+	}
+	return nil
+}
+
+func builtinCall(args []object.Object) (object.Object, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("wrong number of args: want at least 1 got 0")
+	}
+	fn := object.ToValue(args[0])
+	if fn.Kind() != reflect.Func {
+		return nil, fmt.Errorf("non-function of type %s", args[0].Type())
+	}
+	if fn.IsNil() {
+		return nil, fmt.Errorf("call of nil")
+	}
+	if err := object.CheckFunc(fn.Type()); err != nil {
+		return nil, err
+	}
+	return callFunc(fn, args[1:])
+}
+
+// toAny converts objects to Go values, e.g. for formatting them with fmt.
+func toAny(args []object.Object) []any {
+	res := make([]any, len(args))
+	for i, arg := range args {
+		value := object.ToValue(arg)
+		switch {
+		case !value.IsValid():
+			res[i] = nil
+		case value.CanInterface():
+			res[i] = value.Interface()
+		default:
+			// unexported fields can't be turned into an interface, but
+			// fmt knows how to print the reflect.Value itself
+			res[i] = value
+		}
+	}
+	return res
+}
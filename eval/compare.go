@@ -0,0 +1,175 @@
+package eval
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/kvalv/template-mvp/errors"
+	"github.com/kvalv/template-mvp/object"
+)
+
+// The comparison rules are shared by the comparison operators and the
+// comparison builtins such as `eq` and `lt`:
+//   - numbers are compared by value, after promoting them to the same type
+//     like for arithmetic
+//   - strings are compared lexicographically, byte by byte
+//   - booleans can be compared for equality, but have no order
+//   - nil is equal to nil, and to nil pointers, slices, maps etc. Anything
+//     else is not nil
+//   - other Go values can be compared for equality if they have the same,
+//     comparable type
+//
+// Comparing any other combination is an error. Unlike the operators, the
+// builtins don't compare integers with floats, like in text/template.
+
+// equal reports whether a == b.
+func equal(a, b object.Object) (bool, error) {
+	if isNumeric(a) && isNumeric(b) {
+		cmp, err := compareNumbers(a, b)
+		return cmp == 0, err
+	}
+	switch a := a.(type) {
+	case *object.String:
+		if b, ok := b.(*object.String); ok {
+			return a.Value == b.Value, nil
+		}
+	case *object.Boolean:
+		if b, ok := b.(*object.Boolean); ok {
+			return a.Value == b.Value, nil
+		}
+	}
+
+	if _, ok := a.(*object.Nil); ok {
+		a, b = b, a
+	}
+	if _, ok := b.(*object.Nil); ok {
+		return isNil(a), nil
+	}
+
+	av, bv := object.ToValue(a), object.ToValue(b)
+	if av.IsValid() && bv.IsValid() && av.Type() == bv.Type() && av.Type().Comparable() {
+		return av.Equal(bv), nil
+	}
+	return false, incompatible(a, b)
+}
+
+// less reports whether a < b.
+func less(a, b object.Object) (bool, error) {
+	if isNumeric(a) && isNumeric(b) {
+		cmp, err := compareNumbers(a, b)
+		return cmp < 0, err
+	}
+	switch a := a.(type) {
+	case *object.String:
+		if b, ok := b.(*object.String); ok {
+			return a.Value < b.Value, nil
+		}
+	}
+	if a.Type() == b.Type() {
+		return false, fmt.Errorf("%w: %s", errors.ErrNotOrdered, typeName(a))
+	}
+	return false, incompatible(a, b)
+}
+
+// lessOrEqual reports whether a <= b. This is not the same as !(b < a), which
+// is true when one of them is NaN.
+func lessOrEqual(a, b object.Object) (bool, error) {
+	if isNumeric(a) && isNumeric(b) {
+		cmp, err := compareNumbers(a, b)
+		return cmp == -1 || cmp == 0, err
+	}
+	lt, err := less(a, b)
+	if err != nil || lt {
+		return lt, err
+	}
+	return equal(a, b)
+}
+
+// compare evaluates a comparison operator, such as "<=".
+func compare(op string, a, b object.Object) (bool, error) {
+	switch op {
+	case "==":
+		return equal(a, b)
+	case "!=":
+		eq, err := equal(a, b)
+		return !eq, err
+	case "<":
+		return less(a, b)
+	case ">":
+		return less(b, a)
+	case "<=":
+		return lessOrEqual(a, b)
+	case ">=":
+		return lessOrEqual(b, a)
+	default:
+		return false, fmt.Errorf("unknown comparison operator %s", op)
+	}
+}
+
+// compareNumbers returns -1, 0 or 1 if a is less than, equal to or greater
+// than b. NaN is neither, and compares as 2.
+func compareNumbers(a, b object.Object) (int, error) {
+	x, y, err := promote(a, b)
+	if errors.Is(err, errors.ErrOverflow) {
+		// a negative int and a uint that is too big for an int
+		if isNegative(a) {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch x := x.(type) {
+	case *object.Number:
+		return cmp.Compare(x.Value, y.(*object.Number).Value), nil
+	case *object.Uint:
+		return cmp.Compare(x.Value, y.(*object.Uint).Value), nil
+	default:
+		fx, fy := x.(*object.Float).Value, y.(*object.Float).Value
+		if math.IsNaN(fx) || math.IsNaN(fy) {
+			return 2, nil
+		}
+		return cmp.Compare(fx, fy), nil
+	}
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", ">", "<=", ">=":
+		return true
+	default:
+		return false
+	}
+}
+
+// isNil reports whether obj is nil. Pointers and interfaces are dereferenced
+// when a value is looked up, so anything but nil or a nil slice, map etc. is
+// not nil.
+func isNil(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Nil:
+		return true
+	case *object.Native:
+		switch obj.Value.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+			return obj.Value.IsNil()
+		}
+	}
+	return false
+}
+
+func incompatible(a, b object.Object) error {
+	return fmt.Errorf("%w: %s and %s", errors.ErrIncompatibleTypes, typeName(a), typeName(b))
+}
+
+// typeName names the type of an object in error messages. For Go values
+// without a dedicated object type, it's the Go type.
+func typeName(obj object.Object) string {
+	if native, ok := obj.(*object.Native); ok {
+		return native.Value.Type().String()
+	}
+	return string(obj.Type())
+}
@@ -1,34 +1,67 @@
 package eval
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/kvalv/template-mvp/ast"
 	"github.com/kvalv/template-mvp/errors"
 	"github.com/kvalv/template-mvp/object"
 )
 
-func Eval(expr ast.Expression, data any) object.Object {
+func Eval(expr ast.Expression, env *object.Environment) object.Object {
 	switch expr := expr.(type) {
 	case *ast.Number:
 		return &object.Number{Value: expr.Value}
+	case *ast.Uint:
+		return &object.Uint{Value: expr.Value}
+	case *ast.Float:
+		return &object.Float{Value: expr.Value}
 	case *ast.String:
 		return &object.String{Value: expr.Value}
+	case *ast.Dot:
+		return evalData(expr, env)
 	case *ast.Field:
-		return evalField(expr, data)
+		return evalField(expr, env)
 	case *ast.Infix:
-		return evalInfix(expr, data)
+		return evalInfix(expr, env)
 	case *ast.Prefix:
-		return evalPrefix(expr, data)
+		return evalPrefix(expr, env)
+	case *ast.Chain:
+		return evalChain(expr, env)
+	case *ast.Index:
+		return evalIndex(expr, env)
+	case *ast.Slice:
+		return evalSlice(expr, env)
 	case *ast.Cond:
-		return evalCond(expr, data)
+		return evalCond(expr, env)
+	case *ast.Range:
+		return evalRange(expr, env)
+	case *ast.With:
+		return evalWith(expr, env)
 	case *ast.Boolean:
 		if expr.Value {
 			return object.TRUE
 		}
 		return object.FALSE
+	case *ast.Nil:
+		return object.NIL
+	case *ast.List:
+		return evalList(expr, env)
+	case *ast.Identifier:
+		return evalCall(&ast.Call{Token: expr.Token, Fn: expr}, env)
+	case *ast.Call:
+		return evalCall(expr, env)
+	case *ast.Pipeline:
+		return evalPipeline(expr, env)
+	case *ast.Variable:
+		return evalVariable(expr, env)
+	case *ast.Assign:
+		return evalAssign(expr, env)
 	case *ast.Action:
-		return Eval(expr.Body, data)
+		return evalAction(expr, env)
 	case *ast.Text:
 		return &object.String{Value: expr.Text}
 	default:
@@ -36,95 +69,542 @@ func Eval(expr ast.Expression, data any) object.Object {
 	}
 }
 
-func evalPrefix(expr *ast.Prefix, data any) object.Object {
+func evalAction(expr *ast.Action, env *object.Environment) object.Object {
+	obj := Eval(expr.Body, env)
+	// declarations and assignments produce no output
+	if _, ok := expr.Body.(*ast.Assign); ok && !isError(obj) {
+		return &object.Void{}
+	}
+	return obj
+}
+
+func evalPrefix(expr *ast.Prefix, env *object.Environment) object.Object {
 	switch expr.Op {
 	case ".":
-		return evalField(expr.Rhs.(*ast.Field), data)
+		field, ok := expr.Rhs.(*ast.Field)
+		if !ok {
+			return errorf(expr, "can't select %s", expr.Rhs)
+		}
+		return evalField(field, env)
+	case "!":
+		right := Eval(expr.Rhs, env)
+		if isError(right) {
+			return right
+		}
+		return object.FromGoBool(!right.Bool())
+	case "-", "+":
+		right := Eval(expr.Rhs, env)
+		if isError(right) {
+			return right
+		}
+		if !isNumeric(right) {
+			return errorf(expr, "unsupported type for unary %s: %s", expr.Op, typeName(right))
+		}
+		if expr.Op == "+" {
+			return right
+		}
+		result, err := arithmetic("-", &object.Number{Value: 0}, right)
+		if err != nil {
+			return errorf(expr, "%w", err)
+		}
+		return result
 	default:
-		return object.Errorf("unsupported prefix operator %s", expr.Op)
+		return errorf(expr, "unsupported prefix operator %s", expr.Op)
 	}
 }
 
-func evalInfix(expr *ast.Infix, data any) object.Object {
-	left := Eval(expr.Lhs, data)
-	right := Eval(expr.Rhs, data)
+func evalInfix(expr *ast.Infix, env *object.Environment) object.Object {
+	if expr.Op == "&&" || expr.Op == "||" {
+		return evalBooleanInfix(expr, env)
+	}
+	left := Eval(expr.Lhs, env)
+	if isError(left) {
+		return left
+	}
+	right := Eval(expr.Rhs, env)
+	if isError(right) {
+		return right
+	}
+
+	if isComparison(expr.Op) {
+		ok, err := compare(expr.Op, left, right)
+		if err != nil {
+			return errorf(expr, "%w", err)
+		}
+		return object.FromGoBool(ok)
+	}
 
 	switch {
-	case left.Type() == object.NUMBER_OBJ && right.Type() == object.NUMBER_OBJ:
-		return evalNumberInfix(expr.Op, left.(*object.Number), right.(*object.Number))
+	case isNumeric(left) && isNumeric(right):
+		return evalNumberInfix(expr, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfix(expr.Op, left.(*object.String), right.(*object.String))
+		return evalStringInfix(expr, left.(*object.String), right.(*object.String))
 
 	default:
-		return object.Errorf("evalInfix: unsupported types for infix expression: %v %v %v", left.Type(), expr.Op, right.Type())
+		return errorf(expr, "unsupported types for %s: %s and %s", expr.Op, typeName(left), typeName(right))
 	}
 }
 
-func evalNumberInfix(op string, left, right *object.Number) object.Object {
-	switch op {
-	case "+":
-		return &object.Number{Value: left.Value + right.Value}
-	case "-":
-		return &object.Number{Value: left.Value - right.Value}
-	case ">":
-		return object.FromGoBool(left.Value > right.Value)
-	case "<":
-		return object.FromGoBool(left.Value < right.Value)
-	case "==":
-		return object.FromGoBool(left.Value == right.Value)
-	default:
-		return object.Errorf("unsupported operator %s", op)
+// evalBooleanInfix evaluates && and ||. The right-hand side is only
+// evaluated if the left-hand side doesn't decide the result already.
+func evalBooleanInfix(expr *ast.Infix, env *object.Environment) object.Object {
+	left := Eval(expr.Lhs, env)
+	if isError(left) {
+		return left
 	}
+	if expr.Op == "&&" && !left.Bool() {
+		return object.FALSE
+	}
+	if expr.Op == "||" && left.Bool() {
+		return object.TRUE
+	}
+	right := Eval(expr.Rhs, env)
+	if isError(right) {
+		return right
+	}
+	return object.FromGoBool(right.Bool())
 }
 
-func evalStringInfix(op string, left, right *object.String) object.Object {
-	switch op {
+func evalStringInfix(expr *ast.Infix, left, right *object.String) object.Object {
+	switch expr.Op {
 	case "+":
 		return &object.String{Value: left.Value + right.Value}
 	default:
-		return object.Errorf("unsupported operator %s", op)
+		return errorf(expr, "unsupported operator %s", expr.Op)
 	}
 }
 
-func evalField(expr *ast.Field, data any) object.Object {
-	// we'll use reflection to access the field
+func evalField(expr *ast.Field, env *object.Environment) object.Object {
+	return evalSelectors(&ast.Dot{}, []*ast.Field{expr}, env)
+}
+
+func evalChain(expr *ast.Chain, env *object.Environment) object.Object {
+	node, fields, _ := selectors(expr)
+	return evalSelectors(node, fields, env)
+}
+
+// selectors splits a field access such as `.A.B` or `$x.A` into the
+// expression the fields are selected from and the fields themselves.
+func selectors(expr ast.Expression) (ast.Expression, []*ast.Field, bool) {
+	switch expr := expr.(type) {
+	case *ast.Prefix:
+		if field, ok := expr.Rhs.(*ast.Field); ok && expr.Op == "." {
+			return &ast.Dot{Token: expr.Token}, []*ast.Field{field}, true
+		}
+	case *ast.Chain:
+		node, fields, ok := selectors(expr.Node)
+		if ok {
+			return node, append(fields[:len(fields):len(fields)], expr.Fields...), true
+		}
+		return expr.Node, expr.Fields, true
+	}
+	return nil, nil, false
+}
+
+// evalData evaluates `.` or `$`. When the data is nil, it is missing like the
+// fields of it would be, so the missing key policy applies.
+func evalData(expr ast.Node, env *object.Environment) object.Object {
+	value, err := dataValue(expr, env)
+	if err != nil {
+		if missing, ok := env.Missing(value, err); ok {
+			return object.FromValue(missing)
+		}
+		return errorf(expr, "%w", err)
+	}
+	return object.FromValue(value)
+}
+
+// isData reports whether node is `.` or `$`.
+func isData(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.Dot:
+		return true
+	case *ast.Variable:
+		return node.Name == "$"
+	default:
+		return false
+	}
+}
+
+// dataValue returns the Go value of `.` or `$`.
+func dataValue(node ast.Expression, env *object.Environment) (reflect.Value, error) {
+	if _, ok := node.(*ast.Dot); ok {
+		return env.Value(".")
+	}
+	return env.Root()
+}
+
+func evalSelectors(node ast.Expression, fields []*ast.Field, env *object.Environment) object.Object {
+	value, errObj := selectValue(node, fields, env)
+	if errObj != nil {
+		return errObj
+	}
+	return object.FromValue(value)
+}
+
+// selectValue selects the fields one at a time, starting from the value of
+// node, so that an error points at the field that failed. The fields are
+// selected from the Go value rather than an object, so that methods of e.g.
+// named number types are kept.
+func selectValue(node ast.Expression, fields []*ast.Field, env *object.Environment) (reflect.Value, object.Object) {
 	var value reflect.Value
-	if v := reflect.ValueOf(data); v.Kind() == reflect.Pointer {
-		value = v.Elem()
+	if isData(node) {
+		var err error
+		if value, err = dataValue(node, env); err != nil {
+			if missing, ok := env.Missing(value, err); ok {
+				return missing, nil
+			}
+			return reflect.Value{}, errorf(fields[0], "%w", err)
+		}
 	} else {
-		value = v
+		obj := Eval(node, env)
+		if isError(obj) {
+			return reflect.Value{}, obj
+		}
+		value = object.ToValue(obj)
 	}
-	if data == nil {
-		return object.Errorf("%w: %s", errors.ErrNilData, expr.Name)
+	for _, field := range fields {
+		next, err := object.Select(value, field.Name)
+		if err != nil {
+			// the rest of the chain is missing as well
+			if missing, ok := env.Missing(value, err); ok {
+				return missing, nil
+			}
+			return reflect.Value{}, errorf(field, "%w", err)
+		}
+		value = next
 	}
-	if !value.IsValid() {
-		return object.Errorf("evalField: invalid data %+v", data)
+	return value, nil
+}
+
+// evalIndex evaluates `x[i]` like the index builtin, so a missing map key
+// gives the zero value.
+func evalIndex(expr *ast.Index, env *object.Environment) object.Object {
+	args, errObj := evalArgs([]ast.Expression{expr.Node, expr.Index}, env)
+	if errObj != nil {
+		return errObj
 	}
-	if value.Kind() != reflect.Struct {
-		return object.Errorf("evalField: object is not a struct - got %+v", data)
+	obj, err := builtinIndex(args)
+	if err != nil {
+		return errorf(expr, "%w", err)
 	}
+	return obj
+}
 
-	structValue := value.FieldByName(expr.Name)
-	if !structValue.IsValid() {
-		return object.Errorf("%w: %s", errors.ErrFieldNotFound, expr.Name)
+// evalSlice evaluates `x[low:high]` like the slice builtin.
+func evalSlice(expr *ast.Slice, env *object.Environment) object.Object {
+	low, high := expr.Low, expr.High
+	if low == nil {
+		low = &ast.Number{Value: 0}
+	}
+	exprs := []ast.Expression{expr.Node, low}
+	if high != nil {
+		exprs = append(exprs, high)
+	}
+	args, errObj := evalArgs(exprs, env)
+	if errObj != nil {
+		return errObj
 	}
+	obj, err := builtinSlice(args)
+	if err != nil {
+		return errorf(expr, "%w", err)
+	}
+	return obj
+}
 
-	switch structValue.Kind() {
-	case reflect.String:
-		return &object.String{Value: structValue.String()}
-	case reflect.Int:
-		return &object.Number{Value: int(structValue.Int())}
-	default:
-		return object.Errorf("unsupported type %s", structValue.Kind())
+func evalList(list *ast.List, env *object.Environment) object.Object {
+	var out strings.Builder
+	for _, expr := range list.Exprs {
+		obj := Eval(expr, env)
+		if isError(obj) {
+			return obj
+		}
+		out.WriteString(obj.String())
 	}
+	return &object.String{Value: out.String()}
 }
-func evalCond(expr *ast.Cond, data any) object.Object {
-	cond := Eval(expr.If, data)
+
+func evalCond(expr *ast.Cond, env *object.Environment) object.Object {
+	scope := env.Scope()
+	cond := Eval(expr.If, scope)
 	if _, ok := object.AsError(cond); ok {
 		return cond
 	}
 	if cond.Bool() {
-		return Eval(expr.Body, data)
+		return Eval(expr.Body, scope)
+	}
+	if expr.Else != nil {
+		return Eval(expr.Else, scope)
 	}
 	return &object.Void{}
 }
+
+func evalWith(expr *ast.With, env *object.Environment) object.Object {
+	scope := env.Scope()
+	pipe := Eval(expr.Pipe, scope)
+	if _, ok := object.AsError(pipe); ok {
+		return pipe
+	}
+	if pipe.Bool() {
+		return Eval(expr.Body, scope.With(object.ToValue(pipe)))
+	}
+	if expr.Else != nil {
+		return Eval(expr.Else, scope)
+	}
+	return &object.Void{}
+}
+
+func evalRange(expr *ast.Range, env *object.Environment) object.Object {
+	pipe := Eval(expr.Pipe, env)
+	if _, ok := object.AsError(pipe); ok {
+		return pipe
+	}
+	var value reflect.Value
+	switch pipe := pipe.(type) {
+	case *object.Native:
+		value = pipe.Value
+	case *object.Nil:
+		// e.g. a nil pointer, which has no elements
+	case *object.String:
+		// a []byte is printed as a string, but ranges over its bytes
+		if value = pipe.Bytes(); !value.IsValid() {
+			return errorf(expr, "range can't iterate over %s", pipe.Type())
+		}
+	default:
+		return errorf(expr, "range can't iterate over %s", pipe.Type())
+	}
+
+	var out strings.Builder
+	var count int
+	body := func(key, elem reflect.Value) object.Object {
+		count++
+		scope := env.With(elem)
+		if expr.Key != nil {
+			scope.Declare(expr.Key.Name, object.FromValue(key))
+		}
+		if expr.Value != nil {
+			scope.Declare(expr.Value.Name, object.FromValue(elem))
+		}
+		obj := Eval(expr.Body, scope)
+		if _, ok := object.AsError(obj); !ok {
+			out.WriteString(obj.String())
+		}
+		return obj
+	}
+
+	switch value.Kind() {
+	case reflect.Invalid:
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if obj := body(reflect.ValueOf(i), value.Index(i)); isError(obj) {
+				return obj
+			}
+		}
+	case reflect.Map:
+		for _, key := range sortedKeys(value) {
+			if obj := body(key, value.MapIndex(key)); isError(obj) {
+				return obj
+			}
+		}
+	case reflect.Chan:
+		if value.IsNil() {
+			break
+		}
+		for i := 0; ; i++ {
+			elem, ok := value.Recv()
+			if !ok {
+				break
+			}
+			if obj := body(reflect.ValueOf(i), elem); isError(obj) {
+				return obj
+			}
+		}
+	default:
+		return errorf(expr, "range can't iterate over %s", value.Kind())
+	}
+
+	if count == 0 && expr.Else != nil {
+		return Eval(expr.Else, env.Scope())
+	}
+	return &object.String{Value: out.String()}
+}
+
+func evalVariable(expr *ast.Variable, env *object.Environment) object.Object {
+	if isData(expr) {
+		return evalData(expr, env)
+	}
+	value, err := env.Lookup(expr.Name)
+	if err != nil {
+		return errorf(expr, "%w", err)
+	}
+	return value
+}
+
+func evalAssign(expr *ast.Assign, env *object.Environment) object.Object {
+	value := Eval(expr.Value, env)
+	if _, ok := object.AsError(value); ok {
+		return value
+	}
+	if expr.Op == ":=" {
+		env.Declare(expr.Var.Name, value)
+		return value
+	}
+	if err := env.Assign(expr.Var.Name, value); err != nil {
+		return errorf(expr, "%w", err)
+	}
+	return value
+}
+
+// sortedKeys returns the keys of a map in a deterministic order, so that
+// ranging over a map always renders the same output.
+func sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch a.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		case reflect.Float32, reflect.Float64:
+			return a.Float() < b.Float()
+		case reflect.String:
+			return a.String() < b.String()
+		case reflect.Bool:
+			return !a.Bool() && b.Bool()
+		default:
+			return fmt.Sprint(a) < fmt.Sprint(b)
+		}
+	})
+	return keys
+}
+
+func isError(obj object.Object) bool {
+	_, ok := object.AsError(obj)
+	return ok
+}
+
+func evalPipeline(expr *ast.Pipeline, env *object.Environment) object.Object {
+	value := Eval(expr.Cmds[0], env)
+	for _, cmd := range expr.Cmds[1:] {
+		if _, ok := object.AsError(value); ok {
+			return value
+		}
+		switch cmd := cmd.(type) {
+		case *ast.Identifier:
+			value = evalCall(&ast.Call{Token: cmd.Token, Fn: cmd}, env, value)
+		case *ast.Call:
+			value = evalCall(cmd, env, value)
+		case *ast.Prefix, *ast.Chain:
+			value = evalCall(&ast.Call{Token: expr.Token, Fn: cmd}, env, value)
+		default:
+			return errorf(expr, "can't pipe into %s", cmd)
+		}
+	}
+	return value
+}
+
+// evalCall calls a function with the given arguments. The final arguments are
+// appended after the arguments in the expression, and are used for passing
+// the value along in a pipeline. Functions registered by the user take
+// precedence over the builtins.
+func evalCall(expr *ast.Call, env *object.Environment, final ...object.Object) object.Object {
+	if node, fields, ok := selectors(expr.Fn); ok {
+		return evalMethodCall(expr, node, fields, env, final)
+	}
+	ident, ok := expr.Fn.(*ast.Identifier)
+	if !ok {
+		return errorf(expr, "can't call %s", expr.Fn)
+	}
+	name := ident.Name
+	fn, err := env.Func(name)
+	if err != nil {
+		if name == "and" || name == "or" {
+			return evalLogical(expr, name, env, final)
+		}
+		if _, ok := builtins[name]; !ok {
+			return errorf(expr, "%w", err)
+		}
+	}
+
+	args, errObj := evalArgs(expr.Args, env)
+	if errObj != nil {
+		return errObj
+	}
+	args = append(args, final...)
+
+	var result object.Object
+	if fn.IsValid() {
+		result, err = callFunc(fn, args)
+	} else {
+		result, err = builtins[name](args)
+	}
+	if err != nil {
+		return errorf(expr, "error calling %s: %w", name, err)
+	}
+	return result
+}
+
+// evalMethodCall calls a method with arguments, e.g. `.Price.Format "EUR"`,
+// where the method is the last of the fields.
+func evalMethodCall(expr *ast.Call, node ast.Expression, fields []*ast.Field, env *object.Environment, final []object.Object) object.Object {
+	last := fields[len(fields)-1]
+	receiver, errObj := selectValue(node, fields[:len(fields)-1], env)
+	if errObj != nil {
+		return errObj
+	}
+	method, ok := object.Method(receiver, last.Name)
+	if !ok {
+		// a field or key exists, so it's not missing, just not callable
+		if _, err := object.Select(receiver, last.Name); err == nil {
+			return errorf(last, "%w %s", errors.ErrNotCallable, last.Name)
+		}
+		err := fmt.Errorf("%w: %s", errors.ErrMethodNotFound, last.Name)
+		if missing, ok := env.Missing(receiver, err); ok {
+			return object.FromValue(missing)
+		}
+		return errorf(last, "%w", err)
+	}
+	args, errObj := evalArgs(expr.Args, env)
+	if errObj != nil {
+		return errObj
+	}
+	result, err := callFunc(method, append(args, final...))
+	if err != nil {
+		return errorf(last, "error calling %s: %w", last.Name, err)
+	}
+	return result
+}
+
+// evalArgs evaluates the expressions in order. If one of them fails, the
+// error object is returned instead.
+func evalArgs(exprs []ast.Expression, env *object.Environment) ([]object.Object, object.Object) {
+	args := make([]object.Object, 0, len(exprs))
+	for _, expr := range exprs {
+		obj := Eval(expr, env)
+		if isError(obj) {
+			return nil, obj
+		}
+		args = append(args, obj)
+	}
+	return args, nil
+}
+
+// callFunc calls a Go function, converting the arguments to the types it
+// expects.
+func callFunc(fn reflect.Value, args []object.Object) (object.Object, error) {
+	result, err := object.Call(fn, args)
+	if err != nil {
+		return nil, err
+	}
+	return object.FromValue(result), nil
+}
+
+// errorf returns an error object for a failure while evaluating node.
+func errorf(node ast.Node, format string, a ...any) *object.Error {
+	return object.NewError(&errors.ExecError{
+		Span: node.Pos(),
+		Node: node,
+		Err:  fmt.Errorf(format, a...),
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/kvalv/template-mvp/errors"
 	"github.com/kvalv/template-mvp/eval"
 	"github.com/kvalv/template-mvp/object"
+	"github.com/kvalv/template-mvp/token"
 )
 
 func TestFieldAccess(t *testing.T) {
@@ -40,12 +41,38 @@ func TestFieldAccess(t *testing.T) {
 			input: &ast.Field{Name: "Foo"},
 			data:  struct{}{},
 			err:   errors.ErrFieldNotFound,
+		}, {
+			descr: "chain",
+			input: &ast.Chain{
+				Node:   &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+				Fields: []*ast.Field{{Name: "B"}, {Name: "C"}},
+			},
+			data: struct{ A *struct{ B struct{ C int } } }{A: &struct{ B struct{ C int } }{}},
+			want: "0",
+		},
+		{
+			descr: "chain/field not found",
+			input: &ast.Chain{
+				Node:   &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+				Fields: []*ast.Field{{Name: "Nope"}},
+			},
+			data: struct{ A struct{} }{},
+			err:  errors.ErrFieldNotFound,
+		},
+		{
+			descr: "chain/nil pointer",
+			input: &ast.Chain{
+				Node:   &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+				Fields: []*ast.Field{{Name: "B"}},
+			},
+			data: struct{ A *struct{ B int } }{},
+			err:  errors.ErrNilPointer,
 		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.descr, func(t *testing.T) {
-			obj := eval.Eval(tc.input, tc.data)
+			obj := eval.Eval(tc.input, object.NewEnvironment(tc.data))
 			if tc.err != nil {
 				expectErrorObject(t, obj, tc.err)
 				return
@@ -59,6 +86,31 @@ func TestFieldAccess(t *testing.T) {
 	}
 }
 
+func TestChainError(t *testing.T) {
+	at := func(row, col int) token.Token {
+		return token.Token{Span: token.Span{Start: token.Position{Row: row, Col: col}}}
+	}
+	expr := &ast.Chain{
+		Node: &ast.Prefix{Op: ".", Rhs: &ast.Field{Token: at(1, 4), Name: "Order"}},
+		Fields: []*ast.Field{
+			{Token: at(1, 10), Name: "Customer"},
+			{Token: at(1, 19), Name: "Adress"},
+			{Token: at(1, 26), Name: "City"},
+		},
+	}
+	data := struct {
+		Order struct {
+			Customer struct{ Address struct{ City string } }
+		}
+	}{}
+
+	obj := eval.Eval(expr, object.NewEnvironment(data))
+	expectErrorObject(t, obj, errors.ErrFieldNotFound)
+	if want := "1:19: field not found: Adress"; obj.String() != want {
+		t.Fatalf("message mismatch; want=%q, got=%q", want, obj.String())
+	}
+}
+
 func TestEvalField(t *testing.T) {
 	cases := []struct {
 		descr string
@@ -82,7 +134,7 @@ func TestEvalField(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.descr, func(t *testing.T) {
-			obj := eval.Eval(tc.expr, tc.data)
+			obj := eval.Eval(tc.expr, object.NewEnvironment(tc.data))
 			if err, ok := object.AsError(obj); ok {
 				t.Fatalf("unexpected error: %s", err)
 			}
@@ -133,7 +185,7 @@ func TestEvalInfixExpression(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.descr, func(t *testing.T) {
-			obj := eval.Eval(tc.expr, tc.data)
+			obj := eval.Eval(tc.expr, object.NewEnvironment(tc.data))
 			if err, ok := object.AsError(obj); ok {
 				t.Fatalf("unexpected error: %s", err)
 			}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/kvalv/template-mvp/token"
 )
@@ -17,9 +19,13 @@ type Mode int
 
 var keywords = map[string]token.TokenType{
 	"if":    token.IF,
+	"else":  token.ELSE,
 	"end":   token.END,
+	"range": token.RANGE,
+	"with":  token.WITH,
 	"true":  token.TRUE,
 	"false": token.FALSE,
+	"nil":   token.NIL,
 }
 
 const (
@@ -31,6 +37,10 @@ type lexer struct {
 	log *log.Logger
 	inp string
 	pos int
+	// the current line, and the column on it, counted in runes
+	row, col int
+	// where the current action starts, for reporting unterminated ones
+	actionStart token.Position
 	// whether we're inside of an action block or not
 	mode Mode
 	// textMode bool
@@ -41,13 +51,18 @@ func New(input string, logdest io.Writer) *lexer {
 	return &lexer{
 		log: log,
 		inp: input,
+		row: 1,
+		col: 1,
 	}
 }
-func (l *lexer) curr() byte {
+
+// curr returns the current character, or 0 at the end of the input.
+func (l *lexer) curr() rune {
 	if l.pos >= len(l.inp) {
 		return 0
 	}
-	return l.inp[l.pos]
+	r, _ := utf8.DecodeRuneInString(l.inp[l.pos:])
+	return r
 }
 
 // retrieves the next token when the Lexer is in text mode
@@ -57,24 +72,29 @@ func (l *lexer) nextText() token.Token {
 	// Should we leave text mode?
 	if l.curr() == '{' && l.peekNext() == '{' {
 		l.log.Printf("Next(): leaving text mode, entering action mode")
+		l.actionStart = l.position()
 		l.advance()
 		l.advance()
 		l.mode = ModeAction
 		return token.Token{Ttype: token.ACTIONSTART, Text: "{{"}
 	}
 
-	c := l.curr()
-	if c == 0 {
+	if l.pos >= len(l.inp) {
 		return l.eof()
 	}
-	text := l.takewhile(func(b byte) bool {
-		res := !slices.Contains([]byte{'{', '}', 0}, b)
-		return res
-	}, false)
-	l.log.Printf("nextText(): curr=%q text=%q", c, text)
-	l.advance()
+	// text runs up to the next {{, so single braces, e.g. in JSON or CSS,
+	// are part of it
+	length := strings.Index(l.inp[l.pos:], "{{")
+	if length < 0 {
+		length = len(l.inp) - l.pos
+	}
+	start, end := l.pos, l.pos+length
+	for l.pos < end {
+		l.advance()
+	}
+	text := l.inp[start:end]
+	l.log.Printf("nextText(): text=%q", text)
 	return token.Token{Ttype: token.TEXT, Text: text}
-
 }
 
 // retrieves the next token when the Lexer is in action mode
@@ -84,12 +104,27 @@ func (l *lexer) nextAction() token.Token {
 	c := l.curr()
 	switch {
 	case c == 0:
-		return l.eof()
+		// the rest is lexed as text, which is just the EOF
+		l.mode = ModeText
+		return l.errorf("unterminated action starting at %s", l.actionStart)
 	case c == '}' && l.peekNext() == '}' && l.mode == ModeAction:
 		l.advance()
 		l.advance()
 		l.mode = ModeText
 		return token.Token{Ttype: token.ACTIONEND, Text: "}}"}
+	case c == '{' && l.peekNext() == '{':
+		// the action is missing its }}, as another one starts here. The
+		// {{ is lexed again in text mode.
+		l.mode = ModeText
+		return l.errorf("unterminated action starting at %s", l.actionStart)
+	case c == '>' && l.peekNext() == '=':
+		l.advance()
+		l.advance()
+		return token.Token{Ttype: token.GT_EQ, Text: ">="}
+	case c == '<' && l.peekNext() == '=':
+		l.advance()
+		l.advance()
+		return token.Token{Ttype: token.LT_EQ, Text: "<="}
 	case c == '>':
 		l.advance()
 		return token.Token{Ttype: token.GT, Text: ">"}
@@ -100,70 +135,206 @@ func (l *lexer) nextAction() token.Token {
 		l.advance()
 		l.advance()
 		return token.Token{Ttype: token.EQ, Text: "=="}
+	case c == ':' && l.peekNext() == '=':
+		l.advance()
+		l.advance()
+		return token.Token{Ttype: token.DECLARE, Text: ":="}
+	case c == '=':
+		l.advance()
+		return token.Token{Ttype: token.ASSIGN, Text: "="}
+	case c == ':':
+		l.advance()
+		return token.Token{Ttype: token.COLON, Text: ":"}
+	case c == '*':
+		l.advance()
+		return token.Token{Ttype: token.ASTERISK, Text: "*"}
+	case c == '/':
+		l.advance()
+		return token.Token{Ttype: token.SLASH, Text: "/"}
+	case c == '%':
+		l.advance()
+		return token.Token{Ttype: token.PERCENT, Text: "%"}
+	case c == '(':
+		l.advance()
+		return token.Token{Ttype: token.LPAREN, Text: "("}
+	case c == ')':
+		l.advance()
+		return token.Token{Ttype: token.RPAREN, Text: ")"}
+	case c == '[':
+		l.advance()
+		return token.Token{Ttype: token.LBRACKET, Text: "["}
+	case c == ']':
+		l.advance()
+		return token.Token{Ttype: token.RBRACKET, Text: "]"}
+	case c == '&' && l.peekNext() == '&':
+		l.advance()
+		l.advance()
+		return token.Token{Ttype: token.AND, Text: "&&"}
+	case c == '|' && l.peekNext() == '|':
+		l.advance()
+		l.advance()
+		return token.Token{Ttype: token.OR, Text: "||"}
+	case c == '!' && l.peekNext() == '=':
+		l.advance()
+		l.advance()
+		return token.Token{Ttype: token.NOT_EQ, Text: "!="}
+	case c == '!':
+		l.advance()
+		return token.Token{Ttype: token.BANG, Text: "!"}
+	case c == '|':
+		l.advance()
+		return token.Token{Ttype: token.PIPE, Text: "|"}
+	case c == ',':
+		l.advance()
+		return token.Token{Ttype: token.COMMA, Text: ","}
+	case c == '$':
+		l.advance()
+		name := l.takewhile(isIdentChar)
+		return token.Token{Ttype: token.VARIABLE, Text: "$" + name}
+	case c == '.' && (l.afterOperand() || l.prev() == '$'):
+		// a dot attached to an operand selects a field of it, like the
+		// second dot in `.A.B` or the one in `$x.A`
+		l.advance()
+		return token.Token{Ttype: token.SELECTOR, Text: "."}
 	case c == '.':
 		l.advance()
 		return token.Token{Ttype: token.DOT, Text: "."}
+	case (c == '+' || c == '-') && isDigit(l.peekNext()) && !l.afterOperand():
+		// a sign that isn't attached to a preceding operand belongs to the
+		// number, so `-1` in `{{add .A -1}}` is an argument of its own
+		start := l.pos
+		l.advance()
+		l.number()
+		return token.Token{Ttype: token.NUMBER, Text: l.inp[start:l.pos]}
 	case c == '+':
 		l.advance()
 		return token.Token{Ttype: token.PLUS, Text: "+"}
 	case c == '-':
 		l.advance()
 		return token.Token{Ttype: token.MINUS, Text: "-"}
+	case c == '"' || c == '`':
+		return l.quoted(token.STRING, c)
+	case c == '\'':
+		return l.quoted(token.CHAR, c)
 	case isLetter(c):
-		ident := l.takewhile(isLetter, false)
-		l.advance()
-		if ttype, ok := keywords[ident]; ok {
+		// a name right after a dot is a field, even if it's a keyword, like
+		// in `.period.end`
+		field := l.prev() == '.'
+		ident := l.takewhile(isIdentChar)
+		if ttype, ok := keywords[ident]; ok && !field {
 			return token.Token{Ttype: ttype, Text: ident}
 		}
 		return token.Token{Ttype: token.IDENT, Text: ident}
 	case isDigit(c):
-		num := l.takewhile(isDigit, false)
-		l.advance()
-		return token.Token{Ttype: token.NUMBER, Text: num}
+		start := l.pos
+		l.number()
+		return token.Token{Ttype: token.NUMBER, Text: l.inp[start:l.pos]}
 	default:
-		return l.errorf("unexpected %q", c)
+		// skip it, so that the parser can carry on after the error
+		l.advance()
+		return l.errorf("unexpected character %q", c)
 	}
 }
 
 func (l *lexer) Next() token.Token {
 	l.log.Printf("Next(): curr=%q", l.curr())
 
+	// whitespace between tokens isn't part of the span
+	if l.mode == ModeAction {
+		l.skipWhitespace()
+	}
+	start := l.position()
+	var tk token.Token
 	if l.mode == ModeText {
-		return l.nextText()
+		tk = l.nextText()
+	} else {
+		tk = l.nextAction()
 	}
-	return l.nextAction()
+	tk.Span = token.Span{Start: start, End: l.position()}
+	return tk
+}
+
+// position returns the row and column of the current character. Both start at
+// 1, and columns count runes rather than bytes.
+func (l *lexer) position() token.Position {
+	return token.Position{Row: l.row, Col: l.col}
 }
 
-func (l *lexer) takewhile(pred func(b byte) bool, consume bool) string {
-	// starts at current, stops at the end
-	if !pred(l.curr()) {
-		return ""
+// takewhile consumes the characters for which pred holds, and returns them.
+func (l *lexer) takewhile(pred func(r rune) bool) string {
+	start := l.pos
+	for l.pos < len(l.inp) && pred(l.curr()) {
+		l.advance()
 	}
+	return l.inp[start:l.pos]
+}
+
+// quoted lexes a string or character literal that starts at the current
+// position. The text of the token is the literal as written, including the
+// quotes, and is unquoted by the parser. A `}}` inside the quotes does not end
+// the action.
+func (l *lexer) quoted(ttype token.TokenType, quote rune) token.Token {
 	start := l.pos
+	l.advance()
 	for {
-		c := l.peekNext()
-		if c == 0 {
-			break
+		if l.pos >= len(l.inp) {
+			return l.errorf("unterminated quoted string")
 		}
-		if !pred(c) {
-			if consume {
-				l.advance()
-			}
-			break
+		switch c := l.curr(); {
+		case c == quote:
+			l.advance()
+			return token.Token{Ttype: ttype, Text: l.inp[start:l.pos]}
+		case c == '\\' && quote != '`':
+			// skip the escaped character, which may be the quote
+			l.advance()
+		case c == '\n' && quote != '`':
+			return l.errorf("unterminated quoted string")
 		}
 		l.advance()
 	}
-	end := l.pos
-	return l.inp[start : end+1]
 }
-func isLetter(b byte) bool {
-	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+
+// number consumes a number literal that starts at the current position. It
+// is lenient, e.g. `1x` is consumed as well, and the parser reports literals
+// that aren't valid.
+func (l *lexer) number() {
+	start := l.pos
+	for isIdentChar(l.curr()) || l.curr() == '.' {
+		c := l.curr()
+		l.advance()
+		// the exponent may have a sign, e.g. 1e-3 or 0x1p+2. In hex
+		// literals, e is a digit.
+		hex := strings.HasPrefix(strings.ToLower(l.inp[start:l.pos]), "0x")
+		exponent := c == 'p' || c == 'P' || (!hex && (c == 'e' || c == 'E'))
+		if exponent && (l.curr() == '-' || l.curr() == '+') {
+			l.advance()
+		}
+	}
+}
+
+// afterOperand reports whether the current character directly follows the
+// end of an operand, as the minus in `.A-1` does.
+func (l *lexer) afterOperand() bool {
+	prev := l.prev()
+	return isIdentChar(prev) || prev == ')' || prev == ']' || prev == '"' || prev == '\'' || prev == '`'
 }
-func isDigit(b byte) bool {
-	return b >= '0' && b <= '9'
+
+// Identifiers follow the rules of Go: they start with a letter or an
+// underscore, followed by letters, digits and underscores. Letters and
+// digits are those of Unicode.
+func isLetter(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
 }
-func isWhitespace(b byte) bool {
-	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+func isIdentChar(r rune) bool {
+	return isLetter(r) || unicode.IsDigit(r)
+}
+
+// isDigit reports whether r is an ASCII digit, which numbers are made of.
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+func isWhitespace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
 }
 
 func (l *lexer) skipWhitespace() {
@@ -172,24 +343,51 @@ func (l *lexer) skipWhitespace() {
 	}
 }
 
-func (l *lexer) peekNext() byte {
-	if l.pos+1 >= len(l.inp) {
+// prev returns the character before the current one, or 0 at the start.
+func (l *lexer) prev() rune {
+	if l.pos == 0 {
 		return 0
 	}
-	return l.inp[l.pos+1]
+	r, _ := utf8.DecodeLastRuneInString(l.inp[:l.pos])
+	return r
 }
-func (l *lexer) advance() {
-	l.pos++
+
+// peekNext returns the character after the current one, or 0 if there is
+// none.
+func (l *lexer) peekNext() rune {
+	if l.pos >= len(l.inp) {
+		return 0
+	}
+	_, size := utf8.DecodeRuneInString(l.inp[l.pos:])
+	if l.pos+size >= len(l.inp) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(l.inp[l.pos+size:])
+	return r
 }
-func (l *lexer) slice(start, length int) string {
-	return l.inp[l.pos+start : l.pos+start+length]
+
+// advance moves to the next character.
+func (l *lexer) advance() {
+	if l.pos >= len(l.inp) {
+		return
+	}
+	_, size := utf8.DecodeRuneInString(l.inp[l.pos:])
+	if l.curr() == '\n' {
+		l.row++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	l.pos += size
 }
 
 func (l *lexer) eof() token.Token {
 	return token.Token{Ttype: token.EOF, Text: ""}
 }
 
+// errorf returns an ERROR token, which has the message as its text.
 func (l *lexer) errorf(format string, a ...any) token.Token {
-	l.log.Printf("Lexer.errorf: %s", fmt.Sprintf(format, a...))
-	return token.Token{Ttype: token.ERROR, Text: ""}
+	msg := fmt.Sprintf(format, a...)
+	l.log.Printf("Lexer.errorf: %s", msg)
+	return token.Token{Ttype: token.ERROR, Text: msg}
 }
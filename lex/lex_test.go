@@ -69,6 +69,322 @@ func TestLexer(t *testing.T) {
 				{Ttype: token.EOF, Text: ""},
 			},
 		},
+		{
+			descr: "range block",
+			input: "{{range .Items}}x{{else}}y{{end}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.RANGE, Text: "range"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "Items"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.TEXT, Text: "x"},
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.ELSE, Text: "else"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.TEXT, Text: "y"},
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.END, Text: "end"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "variables",
+			input: "{{$x := $}}{{$x = 2}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.VARIABLE, Text: "$x"},
+				{Ttype: token.DECLARE, Text: ":="},
+				{Ttype: token.VARIABLE, Text: "$"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.VARIABLE, Text: "$x"},
+				{Ttype: token.ASSIGN, Text: "="},
+				{Ttype: token.NUMBER, Text: "2"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "range variables",
+			input: "{{range $i, $e := .}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.RANGE, Text: "range"},
+				{Ttype: token.VARIABLE, Text: "$i"},
+				{Ttype: token.COMMA, Text: ","},
+				{Ttype: token.VARIABLE, Text: "$e"},
+				{Ttype: token.DECLARE, Text: ":="},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "pipeline",
+			input: "{{.Title | upper}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "Title"},
+				{Ttype: token.PIPE, Text: "|"},
+				{Ttype: token.IDENT, Text: "upper"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "strings",
+			input: "{{\"a}}b\\\"\" `raw\\n}}` 'x'}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.STRING, Text: `"a}}b\""`},
+				{Ttype: token.STRING, Text: "`raw\\n}}`"},
+				{Ttype: token.CHAR, Text: "'x'"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "braces in text",
+			input: `{"a": {"b": 1}} } {{.A}}{`,
+			want: []token.Token{
+				{Ttype: token.TEXT, Text: `{"a": {"b": 1}} } `},
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "A"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.TEXT, Text: "{"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "literal delimiter",
+			input: `a{{"{{"}}b`,
+			want: []token.Token{
+				{Ttype: token.TEXT, Text: "a"},
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.STRING, Text: `"{{"`},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.TEXT, Text: "b"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "identifiers",
+			input: "{{.Line2 .Total_EUR .Größe $x1 $_ _f 日本}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "Line2"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "Total_EUR"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "Größe"},
+				{Ttype: token.VARIABLE, Text: "$x1"},
+				{Ttype: token.VARIABLE, Text: "$_"},
+				{Ttype: token.IDENT, Text: "_f"},
+				{Ttype: token.IDENT, Text: "日本"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "operators after identifiers",
+			input: "{{.Größe-1 .A2.B}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "Größe"},
+				{Ttype: token.MINUS, Text: "-"},
+				{Ttype: token.NUMBER, Text: "1"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "A2"},
+				{Ttype: token.SELECTOR, Text: "."},
+				{Ttype: token.IDENT, Text: "B"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "multi-byte text",
+			input: "Grüße, 世界 {{.A}} ✓",
+			want: []token.Token{
+				{Ttype: token.TEXT, Text: "Grüße, 世界 "},
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "A"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.TEXT, Text: " ✓"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "keywords as field names",
+			input: "{{.end $p.nil .A.if}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "end"},
+				{Ttype: token.VARIABLE, Text: "$p"},
+				{Ttype: token.SELECTOR, Text: "."},
+				{Ttype: token.IDENT, Text: "nil"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "A"},
+				{Ttype: token.SELECTOR, Text: "."},
+				{Ttype: token.IDENT, Text: "if"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "unterminated string",
+			input: `{{"abc}}`,
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.ERROR, Text: "unterminated quoted string"},
+			},
+		},
+		{
+			descr: "operators",
+			input: "{{(1 * 2) / 3 % 4}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.LPAREN, Text: "("},
+				{Ttype: token.NUMBER, Text: "1"},
+				{Ttype: token.ASTERISK, Text: "*"},
+				{Ttype: token.NUMBER, Text: "2"},
+				{Ttype: token.RPAREN, Text: ")"},
+				{Ttype: token.SLASH, Text: "/"},
+				{Ttype: token.NUMBER, Text: "3"},
+				{Ttype: token.PERCENT, Text: "%"},
+				{Ttype: token.NUMBER, Text: "4"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "boolean operators",
+			input: "{{!a && b || c | d}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.BANG, Text: "!"},
+				{Ttype: token.IDENT, Text: "a"},
+				{Ttype: token.AND, Text: "&&"},
+				{Ttype: token.IDENT, Text: "b"},
+				{Ttype: token.OR, Text: "||"},
+				{Ttype: token.IDENT, Text: "c"},
+				{Ttype: token.PIPE, Text: "|"},
+				{Ttype: token.IDENT, Text: "d"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "comparison operators",
+			input: "{{a != b <= c >= d < e > nil}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.IDENT, Text: "a"},
+				{Ttype: token.NOT_EQ, Text: "!="},
+				{Ttype: token.IDENT, Text: "b"},
+				{Ttype: token.LT_EQ, Text: "<="},
+				{Ttype: token.IDENT, Text: "c"},
+				{Ttype: token.GT_EQ, Text: ">="},
+				{Ttype: token.IDENT, Text: "d"},
+				{Ttype: token.LT, Text: "<"},
+				{Ttype: token.IDENT, Text: "e"},
+				{Ttype: token.GT, Text: ">"},
+				{Ttype: token.NIL, Text: "nil"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "signed numbers",
+			input: "{{-3 + .A-1 - -2 > +4}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.NUMBER, Text: "-3"},
+				{Ttype: token.PLUS, Text: "+"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "A"},
+				{Ttype: token.MINUS, Text: "-"},
+				{Ttype: token.NUMBER, Text: "1"},
+				{Ttype: token.MINUS, Text: "-"},
+				{Ttype: token.NUMBER, Text: "-2"},
+				{Ttype: token.GT, Text: ">"},
+				{Ttype: token.NUMBER, Text: "+4"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "selectors",
+			input: "{{.A.B $.C (.).D .}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "A"},
+				{Ttype: token.SELECTOR, Text: "."},
+				{Ttype: token.IDENT, Text: "B"},
+				{Ttype: token.VARIABLE, Text: "$"},
+				{Ttype: token.SELECTOR, Text: "."},
+				{Ttype: token.IDENT, Text: "C"},
+				{Ttype: token.LPAREN, Text: "("},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.RPAREN, Text: ")"},
+				{Ttype: token.SELECTOR, Text: "."},
+				{Ttype: token.IDENT, Text: "D"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "brackets",
+			input: `{{.A[0].B[1:] $m["k"]-1}}`,
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.DOT, Text: "."},
+				{Ttype: token.IDENT, Text: "A"},
+				{Ttype: token.LBRACKET, Text: "["},
+				{Ttype: token.NUMBER, Text: "0"},
+				{Ttype: token.RBRACKET, Text: "]"},
+				{Ttype: token.SELECTOR, Text: "."},
+				{Ttype: token.IDENT, Text: "B"},
+				{Ttype: token.LBRACKET, Text: "["},
+				{Ttype: token.NUMBER, Text: "1"},
+				{Ttype: token.COLON, Text: ":"},
+				{Ttype: token.RBRACKET, Text: "]"},
+				{Ttype: token.VARIABLE, Text: "$m"},
+				{Ttype: token.LBRACKET, Text: "["},
+				{Ttype: token.STRING, Text: `"k"`},
+				{Ttype: token.RBRACKET, Text: "]"},
+				{Ttype: token.MINUS, Text: "-"},
+				{Ttype: token.NUMBER, Text: "1"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
+		{
+			descr: "number literals",
+			input: "{{0x1F 0x1e-3 1_000 1.5e-3 -2.5 0b101}}",
+			want: []token.Token{
+				{Ttype: token.ACTIONSTART, Text: "{{"},
+				{Ttype: token.NUMBER, Text: "0x1F"},
+				{Ttype: token.NUMBER, Text: "0x1e"},
+				{Ttype: token.MINUS, Text: "-"},
+				{Ttype: token.NUMBER, Text: "3"},
+				{Ttype: token.NUMBER, Text: "1_000"},
+				{Ttype: token.NUMBER, Text: "1.5e-3"},
+				{Ttype: token.NUMBER, Text: "-2.5"},
+				{Ttype: token.NUMBER, Text: "0b101"},
+				{Ttype: token.ACTIONEND, Text: "}}"},
+				{Ttype: token.EOF, Text: ""},
+			},
+		},
 	}
 
 	for _, tc := range cases {
@@ -82,8 +398,107 @@ func TestLexer(t *testing.T) {
 	}
 }
 
+func TestSpans(t *testing.T) {
+	span := func(startRow, startCol, endRow, endCol int) token.Span {
+		return token.Span{
+			Start: token.Position{Row: startRow, Col: startCol},
+			End:   token.Position{Row: endRow, Col: endCol},
+		}
+	}
+	// columns count runes, so é is a single column
+	input := "héllo\n{{ .Totl }}\n{{`a\nb` 1}}"
+	want := []token.Token{
+		{Ttype: token.TEXT, Text: "héllo\n", Span: span(1, 1, 2, 1)},
+		{Ttype: token.ACTIONSTART, Text: "{{", Span: span(2, 1, 2, 3)},
+		{Ttype: token.DOT, Text: ".", Span: span(2, 4, 2, 5)},
+		{Ttype: token.IDENT, Text: "Totl", Span: span(2, 5, 2, 9)},
+		{Ttype: token.ACTIONEND, Text: "}}", Span: span(2, 10, 2, 12)},
+		{Ttype: token.TEXT, Text: "\n", Span: span(2, 12, 3, 1)},
+		{Ttype: token.ACTIONSTART, Text: "{{", Span: span(3, 1, 3, 3)},
+		{Ttype: token.STRING, Text: "`a\nb`", Span: span(3, 3, 4, 3)},
+		{Ttype: token.NUMBER, Text: "1", Span: span(4, 4, 4, 5)},
+		{Ttype: token.ACTIONEND, Text: "}}", Span: span(4, 5, 4, 7)},
+		{Ttype: token.EOF, Text: "", Span: span(4, 7, 4, 7)},
+	}
+
+	lexer := lex.New(input, os.Stderr)
+	for _, tk := range want {
+		got := lexer.Next()
+		expectTokenMatch(t, got, tk)
+		if got.Span != tk.Span {
+			t.Fatalf("Span mismatch for %q: got=%v, want=%v", got.Text, got.Span, tk.Span)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cases := []struct {
+		descr string
+		input string
+		want  token.Token
+	}{
+		{
+			descr: "unexpected character",
+			input: "line 1\nline 2\n{{.Total + 1 @}}",
+			want: token.Token{
+				Ttype: token.ERROR,
+				Text:  "unexpected character '@'",
+				Span:  token.Span{Start: token.Position{Row: 3, Col: 14}, End: token.Position{Row: 3, Col: 15}},
+			},
+		},
+		{
+			// a character that isn't ASCII is skipped as a whole
+			descr: "unexpected rune",
+			input: "{{€}}",
+			want: token.Token{
+				Ttype: token.ERROR,
+				Text:  "unexpected character '€'",
+				Span:  token.Span{Start: token.Position{Row: 1, Col: 3}, End: token.Position{Row: 1, Col: 4}},
+			},
+		},
+		{
+			// the {{ starts a new action, which is lexed as usual
+			descr: "unterminated action before another",
+			input: "Hi {{.Name {{if .Name}}x{{end}}",
+			want: token.Token{
+				Ttype: token.ERROR,
+				Text:  "unterminated action starting at 1:4",
+				Span:  token.Span{Start: token.Position{Row: 1, Col: 12}, End: token.Position{Row: 1, Col: 12}},
+			},
+		},
+		{
+			descr: "unterminated action",
+			input: "abcd{{.Name",
+			want: token.Token{
+				Ttype: token.ERROR,
+				Text:  "unterminated action starting at 1:5",
+				Span:  token.Span{Start: token.Position{Row: 1, Col: 12}, End: token.Position{Row: 1, Col: 12}},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			lexer := lex.New(tc.input, os.Stderr)
+			got := lexer.Next()
+			for got.Ttype != token.ERROR && got.Ttype != token.EOF {
+				got = lexer.Next()
+			}
+			expectTokenMatch(t, got, tc.want)
+			if got.Span != tc.want.Span {
+				t.Fatalf("Span mismatch: got=%v, want=%v", got.Span, tc.want.Span)
+			}
+			// the lexer carries on after the error
+			for got.Ttype != token.EOF {
+				if got = lexer.Next(); got.Ttype == token.ERROR {
+					t.Fatalf("unexpected second error: %q", got.Text)
+				}
+			}
+		})
+	}
+}
+
 func expectTokenMatch(t *testing.T, got, want token.Token) {
-	// TODO: spans?
 	t.Helper()
 	if got.Ttype != want.Ttype {
 		t.Fatalf("TokenType mismatch: got=%q, want=%q (Text=%q)", got.Ttype, want.Ttype, got.Text)
@@ -0,0 +1,106 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/kvalv/template-mvp/errors"
+)
+
+// Call calls a Go function, converting the arguments to the types it
+// expects. A non-nil error returned by the function is returned as is, and a
+// panic is turned into an error.
+func Call(fn reflect.Value, args []Object) (result reflect.Value, err error) {
+	typ := fn.Type()
+	if err := CheckFunc(typ); err != nil {
+		return reflect.Value{}, err
+	}
+	numIn := typ.NumIn()
+	if typ.IsVariadic() {
+		if len(args) < numIn-1 {
+			return reflect.Value{}, fmt.Errorf("wrong number of args: want at least %d got %d", numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return reflect.Value{}, fmt.Errorf("wrong number of args: want %d got %d", numIn, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var argType reflect.Type
+		if typ.IsVariadic() && i >= numIn-1 {
+			argType = typ.In(numIn - 1).Elem()
+		} else {
+			argType = typ.In(i)
+		}
+		value, err := ToArg(arg, argType)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		in[i] = value
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	out := fn.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		return reflect.Value{}, out[1].Interface().(error)
+	}
+	return out[0], nil
+}
+
+// Method returns the exported method with the given name, if value has one.
+// Like in Go, methods with a pointer receiver can be called on values that
+// are addressable, e.g. structs that are reached through a pointer.
+func Method(value reflect.Value, name string) (reflect.Value, bool) {
+	for value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		return reflect.Value{}, false
+	}
+	if value.Kind() != reflect.Pointer && value.CanAddr() {
+		value = value.Addr()
+	}
+	method := value.MethodByName(name)
+	return method, method.IsValid()
+}
+
+// Select returns what `.name` refers to on value: the result of calling the
+// method with that name without arguments, or else the struct field or the
+// entry of a map with string keys.
+func Select(value reflect.Value, name string) (reflect.Value, error) {
+	if method, ok := Method(value, name); ok {
+		result, err := Call(method, nil)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("error calling %s: %w", name, err)
+		}
+		return result, nil
+	}
+	value = indirect(value)
+	if !value.IsValid() {
+		return reflect.Value{}, fmt.Errorf("%w evaluating field %s", errors.ErrNilPointer, name)
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		field := value.FieldByName(name)
+		if !field.IsValid() {
+			return reflect.Value{}, fmt.Errorf("%w: %s", errors.ErrFieldNotFound, name)
+		}
+		return field, nil
+	case reflect.Map:
+		keyType := value.Type().Key()
+		if keyType.Kind() != reflect.String {
+			return reflect.Value{}, fmt.Errorf("can't access key %s on map with %s keys", name, keyType)
+		}
+		entry := value.MapIndex(reflect.ValueOf(name).Convert(keyType))
+		if !entry.IsValid() {
+			return reflect.Value{}, fmt.Errorf("%w: %s", errors.ErrKeyNotFound, name)
+		}
+		return entry, nil
+	default:
+		return reflect.Value{}, fmt.Errorf("can't access field %s on %s", name, value.Kind())
+	}
+}
@@ -8,10 +8,37 @@ import (
 	"github.com/kvalv/template-mvp/errors"
 )
 
+// Environment holds the data that `.` refers to while evaluating a template,
+// along with the variables in scope. Each block gets its own environment,
+// and variables are looked up through the chain of parents.
 type Environment struct {
-	data reflect.Value
+	data       reflect.Value
+	vars       map[string]Object
+	funcs      map[string]reflect.Value
+	missingKey MissingKey
+	parent     *Environment
 }
 
+// MissingKey decides what a field, map key or method that doesn't exist
+// evaluates to. The zero value reports an error.
+type MissingKey struct {
+	Mode MissingKeyMode
+	// Placeholder is used with MissingKeyDefault
+	Placeholder string
+}
+
+type MissingKeyMode int
+
+const (
+	// MissingKeyError stops the execution with an error
+	MissingKeyError MissingKeyMode = iota
+	// MissingKeyZero evaluates to the zero value of the map's element
+	// type, or nil if there is no type to go by, e.g. for a struct field
+	MissingKeyZero
+	// MissingKeyDefault evaluates to the placeholder string
+	MissingKeyDefault
+)
+
 func NewEnvironment(input any) *Environment {
 	var data reflect.Value
 	if v, ok := input.(reflect.Value); ok {
@@ -20,54 +47,184 @@ func NewEnvironment(input any) *Environment {
 		data = reflect.ValueOf(input)
 	}
 
-	return &Environment{
-		data: data,
+	env := &Environment{
+		data:  data,
+		vars:  make(map[string]Object),
+		funcs: make(map[string]reflect.Value),
+	}
+	return env
+}
+
+// Root returns the data the template was executed with, which `$` refers to
+// in every scope.
+func (e *Environment) Root() (reflect.Value, error) {
+	value := indirect(e.root().data)
+	if !value.IsValid() {
+		return reflect.Value{}, fmt.Errorf("%w: %s", errors.ErrNilData, "$")
 	}
+	return value, nil
 }
 
+// field returns the value at the given path, see Select.
 func (e *Environment) field(name string) (reflect.Value, error) {
-	structValue := e.data
+	value := indirect(e.data)
+	if !value.IsValid() {
+		return reflect.Value{}, fmt.Errorf("%w: %s", errors.ErrNilData, name)
+	}
 	for _, part := range strings.Split(name, ".") {
 		if part == "" {
 			continue
 		}
-		if !structValue.IsValid() {
-			return reflect.Value{}, fmt.Errorf("%w: %s", errors.ErrFieldNotFound, name)
-		}
-		structValue = structValue.FieldByName(part)
-		if !structValue.IsValid() {
-			return reflect.Value{}, fmt.Errorf("%w: %s", errors.ErrFieldNotFound, name)
+		var err error
+		if value, err = Select(value, part); err != nil {
+			return reflect.Value{}, err
 		}
 	}
-	return structValue, nil
+	return value, nil
 }
 
 // Field returns the value of the field at the given path.
 func (e *Environment) Field(path string) Object {
 	value, err := e.field(path)
 	if err != nil {
-		return Errorf(err.Error())
+		return &Error{err: err}
 	}
+	return FromValue(value)
+}
 
-	switch value.Kind() {
-	case reflect.String:
-		return &String{Value: value.String()}
-	case reflect.Int:
-		return &Number{Value: int(value.Int())}
-	default:
-		return Errorf("AccessField: field %q - unsupported type %s ", path, value.Kind())
-	}
+// Value returns the Go value of the field at the given path.
+func (e *Environment) Value(path string) (reflect.Value, error) {
+	return e.field(path)
 }
 
 // Child returns a new environment with the field at the given path.
 func (e *Environment) Child(path string) *Environment {
 	field, err := e.field(path)
 	if err != nil {
-		return &Environment{
-			data: reflect.Value{},
-		}
+		return e.With(reflect.Value{})
 	}
+	return e.With(field)
+}
+
+// With returns a new scope where `.` is the given value.
+func (e *Environment) With(value reflect.Value) *Environment {
 	return &Environment{
-		data: field,
+		data:   value,
+		vars:   make(map[string]Object),
+		parent: e,
+	}
+}
+
+// Scope returns a new scope with the same `.` as this one. Variables
+// declared in it are not visible to the parent.
+func (e *Environment) Scope() *Environment {
+	return e.With(e.data)
+}
+
+// Declare declares a variable in the current scope, shadowing any variable
+// with the same name in the parent scopes.
+func (e *Environment) Declare(name string, value Object) {
+	e.vars[name] = value
+}
+
+// Assign sets the value of an already declared variable.
+func (e *Environment) Assign(name string, value Object) error {
+	for env := e; env != nil; env = env.parent {
+		if _, ok := env.vars[name]; ok {
+			env.vars[name] = value
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errors.ErrUndefinedVar, name)
+}
+
+// Lookup returns the value of the variable with the given name.
+func (e *Environment) Lookup(name string) (Object, error) {
+	for env := e; env != nil; env = env.parent {
+		if value, ok := env.vars[name]; ok {
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errors.ErrUndefinedVar, name)
+}
+
+// SetMissingKey sets the policy for things that are missing, see Missing.
+func (e *Environment) SetMissingKey(policy MissingKey) {
+	e.root().missingKey = policy
+}
+
+// Missing applies the missing key policy to an error from selecting a field
+// of value. It reports false if the error should be returned as is, either
+// because the policy says so or because it's not about something missing.
+func (e *Environment) Missing(value reflect.Value, err error) (reflect.Value, bool) {
+	if !errors.Is(err, errors.ErrFieldNotFound) && !errors.Is(err, errors.ErrKeyNotFound) &&
+		!errors.Is(err, errors.ErrMethodNotFound) && !errors.Is(err, errors.ErrNilData) {
+		return reflect.Value{}, false
+	}
+	policy := e.root().missingKey
+	switch policy.Mode {
+	case MissingKeyZero:
+		if value = indirect(value); value.Kind() == reflect.Map {
+			return reflect.Zero(value.Type().Elem()), true
+		}
+		return reflect.Zero(reflect.TypeFor[any]()), true
+	case MissingKeyDefault:
+		return reflect.ValueOf(policy.Placeholder), true
+	default:
+		return reflect.Value{}, false
+	}
+}
+
+var errorType = reflect.TypeFor[error]()
+
+// CheckFunc checks that a function can be called from a template, i.e. that
+// it returns a single value, or a value and an error.
+func CheckFunc(typ reflect.Type) error {
+	switch {
+	case typ.NumOut() == 1:
+		return nil
+	case typ.NumOut() == 2 && typ.Out(1) == errorType:
+		return nil
+	default:
+		return fmt.Errorf("function must return a value, or a value and an error")
+	}
+}
+
+// DefineFunc makes a Go function callable from the template.
+func (e *Environment) DefineFunc(name string, fn any) error {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func {
+		return fmt.Errorf("%s is not a function, got %T", name, fn)
+	}
+	if err := CheckFunc(value.Type()); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	e.root().funcs[name] = value
+	return nil
+}
+
+// Func returns the function defined with the given name.
+func (e *Environment) Func(name string) (reflect.Value, error) {
+	fn, ok := e.root().funcs[name]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%w: %s", errors.ErrUndefinedFunc, name)
+	}
+	return fn, nil
+}
+
+func (e *Environment) root() *Environment {
+	env := e
+	for env.parent != nil {
+		env = env.parent
+	}
+	return env
+}
+
+// indirect dereferences pointers and interfaces. The result is invalid if
+// one of them is nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
 	}
+	return v
 }
@@ -1,8 +1,10 @@
 package object_test
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/kvalv/template-mvp/errors"
 	"github.com/kvalv/template-mvp/object"
 )
 
@@ -56,6 +58,24 @@ func TestEnvironment(t *testing.T) {
 				data:  123,
 				want:  &object.Number{Value: 123},
 			},
+			{
+				descr: "through pointers",
+				key:   "child.value",
+				data:  &struct{ child *struct{ value uint8 } }{child: &struct{ value uint8 }{value: 7}},
+				want:  &object.Uint{Value: 7},
+			},
+			{
+				descr: "through interface",
+				key:   "child.value",
+				data:  struct{ child any }{child: struct{ value float64 }{value: 1.5}},
+				want:  &object.Float{Value: 1.5},
+			},
+			{
+				descr: "nil pointer",
+				key:   "child",
+				data:  struct{ child *int }{},
+				want:  object.NIL,
+			},
 		}
 
 		for _, tc := range cases {
@@ -73,6 +93,61 @@ func TestEnvironment(t *testing.T) {
 		expectObjectEq(t, got, want)
 	})
 
+	t.Run("with", func(t *testing.T) {
+		child := object.NewEnvironment(input).Field("child")
+		native, ok := child.(*object.Native)
+		if !ok {
+			t.Fatalf("expected native object, got=%T", child)
+		}
+		got := object.NewEnvironment(nil).With(native.Value).Field("value")
+		want := &object.Number{Value: 123}
+		expectObjectEq(t, got, want)
+	})
+
+	t.Run("scope", func(t *testing.T) {
+		env := object.NewEnvironment(input)
+		env.Declare("$x", &object.Number{Value: 1})
+
+		scope := env.Scope()
+		scope.Declare("$y", &object.Number{Value: 2})
+		if err := scope.Assign("$x", &object.Number{Value: 3}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		got, err := env.Lookup("$x")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expectObjectEq(t, got, &object.Number{Value: 3})
+
+		if _, err := env.Lookup("$y"); !errors.Is(err, errors.ErrUndefinedVar) {
+			t.Fatalf("expected %q, got=%v", errors.ErrUndefinedVar, err)
+		}
+		if err := env.Assign("$z", object.TRUE); !errors.Is(err, errors.ErrUndefinedVar) {
+			t.Fatalf("expected %q, got=%v", errors.ErrUndefinedVar, err)
+		}
+	})
+
+	t.Run("root", func(t *testing.T) {
+		got, err := object.NewEnvironment(input).Child("child").Root()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got.Kind() != reflect.Struct {
+			t.Fatalf("kind mismatch; want=%s, got=%s", reflect.Struct, got.Kind())
+		}
+		if _, err := object.NewEnvironment(nil).Root(); !errors.Is(err, errors.ErrNilData) {
+			t.Fatalf("expected %q, got=%v", errors.ErrNilData, err)
+		}
+	})
+
+	t.Run("field of nil pointer", func(t *testing.T) {
+		got := object.NewEnvironment(struct{ child *struct{ value int } }{}).Field("child.value")
+		if err, ok := object.AsError(got); !ok || !errors.Is(err, errors.ErrNilPointer) {
+			t.Fatalf("expected %q, got=%s", errors.ErrNilPointer, got)
+		}
+	})
+
 	t.Run("invalid", func(t *testing.T) {
 		got := object.NewEnvironment(nil).Field("field")
 		if _, ok := object.AsError(got); !ok {
@@ -1,19 +1,31 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/kvalv/template-mvp/errors"
+)
 
 type ObjectType string
 
 const (
 	STRING_OBJ  = "STRING"
 	NUMBER_OBJ  = "NUMBER"
+	UINT_OBJ    = "UINT"
+	FLOAT_OBJ   = "FLOAT"
 	ERROR_OBJ   = "ERROR"
 	BOOLEAN_OBJ = "BOOLEAN"
+	NATIVE_OBJ  = "NATIVE"
+	NIL_OBJ     = "NIL"
 )
 
 var (
 	TRUE  = &Boolean{Value: true}
 	FALSE = &Boolean{Value: false}
+	NIL   = &Nil{}
 )
 
 type Object interface {
@@ -23,21 +35,75 @@ type Object interface {
 }
 
 type (
-	String  struct{ Value string }
-	Number  struct{ Value int }
+	String struct {
+		Value string
+		typed
+		// bytes is the []byte the string was made from, if any
+		bytes reflect.Value
+	}
+	// Number is a signed integer. Unsigned integers and floats have
+	// types of their own, see the promotion rules in eval.
+	Number struct {
+		Value int64
+		typed
+	}
+	Uint struct {
+		Value uint64
+		typed
+	}
+	Float struct {
+		Value float64
+		typed
+	}
 	Error   struct{ err error }
-	Boolean struct{ Value bool }
-	Void    struct{}
+	Boolean struct {
+		Value bool
+		typed
+	}
+	Void struct{}
+	Nil  struct{}
+	// Native holds a Go value that has no dedicated object type, such
+	// as a slice, map or struct.
+	Native struct{ Value reflect.Value }
 )
 
+// typed is the Go value an object was made from, if it is of a named type
+// such as `type Money float64`. It is what the object converts back to, so
+// that the methods of the type can still be called, e.g. after the value was
+// assigned to a variable.
+type typed struct{ value reflect.Value }
+
+func (t typed) original() reflect.Value { return t.value }
+
+// keepType returns the typed for value, which is empty unless value is of a
+// named type.
+func keepType(value reflect.Value) typed {
+	if value.Type().Name() == value.Kind().String() || !value.CanInterface() {
+		return typed{}
+	}
+	return typed{value: value}
+}
+
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) String() string   { return s.Value }
 func (s *String) Bool() bool       { return s.Value != "" }
 
+// Bytes returns the []byte the string was made from, which can be ranged
+// over, or an invalid value if it wasn't made from one.
+func (s *String) Bytes() reflect.Value { return s.bytes }
+
 func (n *Number) Type() ObjectType { return NUMBER_OBJ }
 func (n *Number) String() string   { return fmt.Sprintf("%d", n.Value) }
 func (n *Number) Bool() bool       { return n.Value != 0 }
 
+func (u *Uint) Type() ObjectType { return UINT_OBJ }
+func (u *Uint) String() string   { return strconv.FormatUint(u.Value, 10) }
+func (u *Uint) Bool() bool       { return u.Value != 0 }
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) String() string   { return strconv.FormatFloat(f.Value, 'g', -1, 64) }
+func (f *Float) Bool() bool       { return f.Value != 0 }
+
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) String() string   { return fmt.Sprintf("%t", b.Value) }
 func (b *Boolean) Bool() bool       { return b.Value }
@@ -52,12 +118,188 @@ func (v *Void) Type() ObjectType { return "VOID" }
 func (v *Void) String() string   { return "" }
 func (v *Void) Bool() bool       { return false }
 
+func (n *Nil) Type() ObjectType { return NIL_OBJ }
+func (n *Nil) String() string   { return "<nil>" }
+func (n *Nil) Bool() bool       { return false }
+
+func (n *Native) Type() ObjectType { return NATIVE_OBJ }
+func (n *Native) String() string   { return fmt.Sprint(n.Value) }
+func (n *Native) Bool() bool {
+	switch n.Value.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return n.Value.Len() > 0
+	default:
+		return true
+	}
+}
+
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) String() string   { return e.err.Error() }
 func (e *Error) Unwrap() error    { return e.err }
 func (e *Error) Error() string    { return e.err.Error() }
 func (e *Error) Bool() bool       { return true }
 
+// FromValue converts a Go value to the matching object. Byte slices
+// become strings.
+func FromValue(value reflect.Value) Object {
+	switch value.Kind() {
+	case reflect.String:
+		return &String{Value: value.String(), typed: keepType(value)}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Number{Value: value.Int(), typed: keepType(value)}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Uint{Value: value.Uint(), typed: keepType(value)}
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: value.Float(), typed: keepType(value)}
+	case reflect.Bool:
+		if t := keepType(value); t.value.IsValid() {
+			return &Boolean{Value: value.Bool(), typed: t}
+		}
+		return FromGoBool(value.Bool())
+	case reflect.Pointer, reflect.Interface:
+		// pointers and interfaces are transparent, so a nil one is nil
+		// and any other is the value it points to
+		if value.IsNil() {
+			return NIL
+		}
+		return FromValue(value.Elem())
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return &String{Value: string(value.Bytes()), bytes: value}
+		}
+		return &Native{Value: value}
+	case reflect.Array, reflect.Map, reflect.Chan, reflect.Struct, reflect.Func,
+		reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return &Native{Value: value}
+	case reflect.Invalid:
+		return Errorf("%w", errors.ErrNilData)
+	default:
+		return Errorf("unsupported type %s", value.Kind())
+	}
+}
+
+// ToValue converts an object back to a Go value, which is the original value
+// for objects of a named type.
+func ToValue(obj Object) reflect.Value {
+	if obj, ok := obj.(interface{ original() reflect.Value }); ok {
+		if value := obj.original(); value.IsValid() {
+			return value
+		}
+	}
+	switch obj := obj.(type) {
+	case *String:
+		return reflect.ValueOf(obj.Value)
+	case *Number:
+		// integer literals are ints in Go, so prefer that where possible
+		if int64(int(obj.Value)) == obj.Value {
+			return reflect.ValueOf(int(obj.Value))
+		}
+		return reflect.ValueOf(obj.Value)
+	case *Uint:
+		return reflect.ValueOf(obj.Value)
+	case *Float:
+		return reflect.ValueOf(obj.Value)
+	case *Boolean:
+		return reflect.ValueOf(obj.Value)
+	case *Native:
+		return obj.Value
+	default:
+		return reflect.Value{}
+	}
+}
+
+// ToArg converts an object to a Go value of the given type, so that it can
+// be passed as an argument to a function.
+func ToArg(obj Object, typ reflect.Type) (reflect.Value, error) {
+	value := ToValue(obj)
+	if _, ok := obj.(*Nil); ok {
+		switch typ.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+			return reflect.Zero(typ), nil
+		}
+	}
+	if !value.IsValid() {
+		return reflect.Value{}, fmt.Errorf("can't use %s as %s", obj.Type(), typ)
+	}
+	if value.Type().AssignableTo(typ) {
+		return value, nil
+	}
+	// pointers were dereferenced by FromValue
+	if value.CanAddr() && value.Addr().Type().AssignableTo(typ) {
+		return value.Addr(), nil
+	}
+	switch {
+	case isNumber(value.Kind()) && isNumber(typ.Kind()):
+		return convertNumber(value, typ)
+	case value.Kind() == typ.Kind() && value.Type().ConvertibleTo(typ):
+		// objects don't keep named types, e.g. a `type Status string`
+		// is a plain string by now
+		return value.Convert(typ), nil
+	case value.Kind() == reflect.String && typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8:
+		// byte slices became strings in FromValue
+		return value.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("can't use %s as %s", value.Type(), typ)
+}
+
+// convertNumber converts a number to the given numeric type. Unlike
+// reflect's Convert, it fails instead of wrapping around when the number
+// doesn't fit, and it doesn't truncate floats.
+func convertNumber(value reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	target := reflect.New(typ).Elem()
+	var overflows bool
+	switch {
+	case value.CanInt():
+		v := value.Int()
+		switch {
+		case target.CanInt():
+			overflows = target.OverflowInt(v)
+		case target.CanUint():
+			overflows = v < 0 || target.OverflowUint(uint64(v))
+		}
+	case value.CanUint():
+		v := value.Uint()
+		switch {
+		case target.CanInt():
+			overflows = v > math.MaxInt64 || target.OverflowInt(int64(v))
+		case target.CanUint():
+			overflows = target.OverflowUint(v)
+		}
+	case value.CanFloat():
+		v := value.Float()
+		switch {
+		case target.CanFloat():
+			overflows = target.OverflowFloat(v)
+		case v != math.Trunc(v):
+			return reflect.Value{}, fmt.Errorf("can't use %v as %s", v, typ)
+		case target.CanInt():
+			overflows = v < math.MinInt64 || v >= math.MaxInt64 || target.OverflowInt(int64(v))
+		case target.CanUint():
+			overflows = v < 0 || v >= math.MaxUint64 || target.OverflowUint(uint64(v))
+		}
+	}
+	if overflows {
+		return reflect.Value{}, fmt.Errorf("%w: %v doesn't fit in %s", errors.ErrOverflow, value, typ)
+	}
+	return value.Convert(typ), nil
+}
+
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewError returns an error object for err.
+func NewError(err error) *Error {
+	return &Error{err: err}
+}
+
 func Errorf(format string, args ...interface{}) *Error {
 	return &Error{err: fmt.Errorf(format, args...)}
 }
@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 
 	"github.com/kvalv/template-mvp/ast"
@@ -23,6 +24,8 @@ type parser struct {
 	prefixFns map[token.TokenType]PrefixFn
 
 	tr trace.Tracer
+	// the errors so far, parsing continues after each of them
+	errs errors.ErrorList
 }
 
 func New(lex lex.Lexer, logdest io.Writer) *parser {
@@ -36,15 +39,32 @@ func New(lex lex.Lexer, logdest io.Writer) *parser {
 	p.prefixFns[token.ACTIONSTART] = p.parseAction
 	p.prefixFns[token.TEXT] = p.parseText
 	p.prefixFns[token.IDENT] = p.parseIdentifier
-	p.prefixFns[token.DOT] = p.parsePrefixExpression
+	p.prefixFns[token.VARIABLE] = p.parseVariable
+	p.prefixFns[token.DOT] = p.parseDot
 	p.prefixFns[token.NUMBER] = p.parseNumber
+	p.prefixFns[token.STRING] = p.parseString
+	p.prefixFns[token.CHAR] = p.parseChar
 	p.prefixFns[token.IF] = p.parseCond
+	p.prefixFns[token.RANGE] = p.parseRange
+	p.prefixFns[token.WITH] = p.parseWith
 	p.prefixFns[token.TRUE] = p.parseBoolean
 	p.prefixFns[token.FALSE] = p.parseBoolean
+	p.prefixFns[token.NIL] = p.parseNil
 
-	for _, tk := range []token.TokenType{token.PLUS, token.MINUS, token.GT, token.LT, token.EQ} {
+	p.prefixFns[token.LPAREN] = p.parseGroup
+	p.prefixFns[token.BANG] = p.parsePrefixExpression
+	p.prefixFns[token.MINUS] = p.parsePrefixExpression
+	p.prefixFns[token.PLUS] = p.parsePrefixExpression
+
+	for _, tk := range []token.TokenType{token.PLUS, token.MINUS, token.ASTERISK, token.SLASH, token.PERCENT, token.GT, token.LT, token.EQ, token.NOT_EQ, token.LT_EQ, token.GT_EQ, token.AND, token.OR} {
 		p.infixFns[tk] = p.parseInfixExpression
 	}
+	p.infixFns[token.NUMBER] = p.parseSignedNumber
+	p.infixFns[token.SELECTOR] = p.parseSelector
+	p.infixFns[token.LBRACKET] = p.parseIndex
+	p.infixFns[token.PIPE] = p.parsePipeline
+	p.infixFns[token.DECLARE] = p.parseAssign
+	p.infixFns[token.ASSIGN] = p.parseAssign
 
 	p.advance()
 	p.advance()
@@ -74,7 +94,7 @@ func (p *parser) parseText() ast.Expression {
 
 func (p *parser) parseAction() ast.Expression {
 	// an action is delimited by {{ and }}
-	defer p.tr.Trace("parseAction")
+	defer p.tr.Trace("parseAction")()
 	p.expectToken(token.ACTIONSTART)
 	expr := &ast.Action{
 		Token: p.curr,
@@ -85,7 +105,7 @@ func (p *parser) parseAction() ast.Expression {
 	// The last token for Conditionals is ACTIONEND, but for other
 	// expressions it's not. So we just ensure that in any case,
 	// The last token is ACTIONEND. 🤷
-	if p.next.Ttype == token.ACTIONEND {
+	if p.curr.Ttype != token.ACTIONEND {
 		p.advance()
 	}
 
@@ -100,20 +120,171 @@ func (p *parser) parseCond() ast.Expression {
 		Token: p.curr,
 	}
 	p.advance()
-	cond.If = p.parseExpression(PrecedenceLowest)
+	p.parseHeader(func() {
+		cond.If = p.parseExpression(PrecedenceLowest)
+	})
+	p.advance()
+	cond.Body = p.parseList(token.ELSE, token.END)
+
+	p.expectToken(token.ACTIONSTART, "(missing {{end}}?)")
+	p.advance()
+	if p.curr.Ttype == token.ELSE {
+		p.advance()
+		// {{else if ...}} shares the {{end}} of the outer conditional,
+		// so the nested one consumes it for us.
+		if p.curr.Ttype == token.IF {
+			cond.Else = p.parseCond()
+			return cond
+		}
+		p.expectToken(token.ACTIONEND)
+		p.advance()
+		cond.Else = p.parseList(token.END)
+		p.expectToken(token.ACTIONSTART, "(missing {{end}}?)")
+		p.advance()
+	}
+	p.expectToken(token.END)
 	p.advance()
 	p.expectToken(token.ACTIONEND)
+
+	return cond
+}
+
+func (p *parser) parseRange() ast.Expression {
+	defer p.tr.Trace("parseRange")()
+	p.expectToken(token.RANGE)
+	rng := &ast.Range{
+		Token: p.curr,
+	}
 	p.advance()
-	cond.Body = p.parseExpression(PrecedenceLowest)
+	p.parseHeader(func() {
+		// range $value := pipeline, or range $key, $value := pipeline
+		if p.curr.Ttype == token.VARIABLE && (p.next.Ttype == token.COMMA || p.next.Ttype == token.DECLARE) {
+			rng.Value = p.parseVariable().(*ast.Variable)
+			p.advance()
+			if p.curr.Ttype == token.COMMA {
+				p.advance()
+				p.expectToken(token.VARIABLE)
+				rng.Key = rng.Value
+				rng.Value = p.parseVariable().(*ast.Variable)
+				p.advance()
+			}
+			p.expectToken(token.DECLARE)
+			p.advance()
+		}
+		rng.Pipe = p.parseExpression(PrecedenceLowest)
+	})
 	p.advance()
+	rng.Body, rng.Else = p.parseBranches()
+	return rng
+}
 
-	p.expectToken(token.ACTIONSTART)
+func (p *parser) parseWith() ast.Expression {
+	defer p.tr.Trace("parseWith")()
+	p.expectToken(token.WITH)
+	with := &ast.With{
+		Token: p.curr,
+	}
 	p.advance()
+	p.parseHeader(func() {
+		with.Pipe = p.parseExpression(PrecedenceLowest)
+	})
+	p.advance()
+	with.Body, with.Else = p.parseBranches()
+	return with
+}
+
+// parseBranches parses the body of a block, and the optional {{else}}
+// branch, up to and including the closing {{end}}.
+func (p *parser) parseBranches() (body, alt *ast.List) {
+	defer p.tr.Trace("parseBranches")()
+	body = p.parseList(token.ELSE, token.END)
+
+	p.expectToken(token.ACTIONSTART, "(missing {{end}}?)")
+	p.advance()
+	if p.curr.Ttype == token.ELSE {
+		p.advance()
+		p.expectToken(token.ACTIONEND)
+		p.advance()
+		alt = p.parseList(token.END)
+		p.expectToken(token.ACTIONSTART, "(missing {{end}}?)")
+		p.advance()
+	}
 	p.expectToken(token.END)
 	p.advance()
 	p.expectToken(token.ACTIONEND)
+	return body, alt
+}
 
-	return cond
+// parseList parses text and actions until EOF, or until an action that
+// starts with one of the given keywords, e.g. {{end}}. The current token is
+// then the ACTIONSTART of that action.
+func (p *parser) parseList(keywords ...token.TokenType) *ast.List {
+	defer p.tr.Trace("parseList")()
+	list := &ast.List{
+		Token: p.curr,
+	}
+	for p.curr.Ttype != token.EOF {
+		if p.curr.Ttype == token.ACTIONSTART && slices.Contains(keywords, p.next.Ttype) {
+			break
+		}
+		if expr := p.parseItem(); expr != nil {
+			list.Exprs = append(list.Exprs, expr)
+		}
+		p.advance()
+	}
+	return list
+}
+
+// parseItem parses a text or an action of a list. If it has a syntax error,
+// the action is left out and parsing continues after it.
+func (p *parser) parseItem() (expr ast.Expression) {
+	defer p.synchronize()
+	return p.parseExpression(PrecedenceLowest)
+}
+
+// parseHeader calls parse to parse what follows the keyword of a block, e.g.
+// the condition of {{if .A}}, and moves on to the }} that ends it. If it has
+// a syntax error, the block is still parsed, so that its {{end}} is matched.
+func (p *parser) parseHeader(parse func()) {
+	defer p.synchronize()
+	parse()
+	p.advance()
+	p.expectToken(token.ACTIONEND)
+}
+
+// synchronize recovers from a syntax error, which the parser panics with. It
+// records the error and skips to the }} of the action it happened in. If the
+// action has no }}, it stops right before the next {{ instead, so that e.g.
+// a block that follows is parsed as usual.
+func (p *parser) synchronize() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	p.errs = append(p.errs, p.parseError(r))
+	for p.curr.Ttype != token.ACTIONEND && p.curr.Ttype != token.EOF && p.next.Ttype != token.ACTIONSTART {
+		p.advance()
+	}
+}
+
+// lexError is the error for an ERROR token, which the lexer returns for input
+// it can't make sense of. The text of the token is the message.
+func lexError(tk token.Token) *errors.ParseError {
+	return &errors.ParseError{Span: tk.Span, Err: errors.New(tk.Text)}
+}
+
+// parseError turns what the parser panicked with into an error. Unless it
+// says otherwise, the error is reported at the token the parser was looking
+// at.
+func (p *parser) parseError(r any) *errors.ParseError {
+	switch r := r.(type) {
+	case *errors.ParseError:
+		return r
+	case error:
+		return &errors.ParseError{Span: p.curr.Span, Err: r}
+	default:
+		return &errors.ParseError{Span: p.curr.Span, Err: fmt.Errorf("%v", r)}
+	}
 }
 
 func (p *parser) parseBoolean() ast.Expression {
@@ -124,34 +295,54 @@ func (p *parser) parseBoolean() ast.Expression {
 	}
 }
 
+func (p *parser) parseNil() ast.Expression {
+	defer p.tr.Trace("parseNil")()
+	return &ast.Nil{Token: p.curr}
+}
+
 func (p *parser) expectToken(ttype token.TokenType, extra ...string) {
+	if p.curr.Ttype == token.ERROR && ttype != token.ERROR {
+		panic(lexError(p.curr))
+	}
 	if p.curr.Ttype != ttype {
-		msg := fmt.Sprintf("expected token type %q, got %q", ttype, p.curr.Ttype)
+		msg := fmt.Sprintf("expected %q, got %q", ttype, p.curr.Ttype)
 		for _, e := range extra {
 			msg += " " + e
 		}
-		panic(msg)
+		panic(fmt.Errorf("%w: %s", errors.ErrUnexpectedToken, msg))
 	}
 }
 
 func (p *parser) parseExpression(precedence int) ast.Expression {
 	defer p.tr.Trace("parseExpression")()
+	if p.curr.Ttype == token.ERROR {
+		panic(lexError(p.curr))
+	}
 	fn, ok := p.prefixFns[p.curr.Ttype]
 	if !ok {
-		panic(fmt.Errorf("no prefixFn found for %q", p.curr.Ttype))
+		panic(fmt.Errorf("%w %q", errors.ErrUnexpectedToken, p.curr.Ttype))
 	}
 	expr := fn()
 
 	// fmt.Printf("parseExpression: expr=%q, next=%q\n", expr, p.next.Ttype)
 
-	for p.next.Ttype != token.EOF && p.next.Ttype != token.ACTIONEND && precedence < tokenPrecedence(p.next.Ttype) {
+	for p.next.Ttype != token.EOF && p.next.Ttype != token.ACTIONEND {
+		// a function or method followed by an operand is a call, e.g.
+		// `len .Items` or `.Price.Format "EUR"`
+		if precedence < PrecedenceCall && p.startsCall(expr) {
+			expr = p.parseCall(expr)
+			continue
+		}
+		if precedence >= infixPrecedence(p.next) {
+			break
+		}
 		p.advance()
 		infixFn, ok := p.infixFns[p.curr.Ttype]
 		if !ok {
-			panic(fmt.Errorf("no infixFn found for %q", p.curr.Ttype))
+			panic(fmt.Errorf("%w %q", errors.ErrUnexpectedToken, p.curr.Ttype))
 		}
 		expr = infixFn(
-			tokenPrecedence(p.curr.Ttype),
+			infixPrecedence(p.curr),
 			expr,
 		)
 	}
@@ -159,20 +350,67 @@ func (p *parser) parseExpression(precedence int) ast.Expression {
 	return expr
 }
 
-func (p *parser) parsePrefixExpression() ast.Expression {
-	defer p.tr.Trace("parsePrefixExpression")()
+// startsCall reports whether expr is called with the next token as its first
+// argument. A signed number after a field is a sum instead, so that `.A -1`
+// keeps meaning `.A - 1`.
+func (p *parser) startsCall(expr ast.Expression) bool {
+	if !startsOperand(p.next.Ttype) {
+		return false
+	}
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		return true
+	case *ast.Chain:
+		return !isSigned(p.next)
+	case *ast.Prefix:
+		return expr.Op == "." && !isSigned(p.next)
+	default:
+		return false
+	}
+}
+
+func (p *parser) parseDot() ast.Expression {
+	defer p.tr.Trace("parseDot")()
+	// a dot on its own refers to the data itself
+	if p.next.Ttype != token.IDENT {
+		return &ast.Dot{Token: p.curr}
+	}
 	// current is dot, next is then a field
 	expr := &ast.Prefix{
 		Token: p.curr,
 		Op:    p.curr.Text,
 	}
 	p.advance()
-	if expr.Rhs = p.parseExpression(PrecedencePrefix); expr.Rhs == nil {
-		return nil
+	expr.Rhs = &ast.Field{
+		Token: p.curr,
+		Name:  p.curr.Text,
 	}
 	return expr
 }
 
+// parsePrefixExpression parses a unary operator, e.g. `!.Locked`
+func (p *parser) parsePrefixExpression() ast.Expression {
+	defer p.tr.Trace("parsePrefixExpression")()
+	expr := &ast.Prefix{
+		Token: p.curr,
+		Op:    p.curr.Text,
+	}
+	p.advance()
+	expr.Rhs = p.parseExpression(PrecedencePrefix)
+	return expr
+}
+
+// parseGroup parses a parenthesized expression, e.g. `(.A + .B)`.
+func (p *parser) parseGroup() ast.Expression {
+	defer p.tr.Trace("parseGroup")()
+	p.expectToken(token.LPAREN)
+	p.advance()
+	expr := p.parseExpression(PrecedenceLowest)
+	p.advance()
+	p.expectToken(token.RPAREN, "(missing closing parenthesis?)")
+	return expr
+}
+
 func (p *parser) parseInfixExpression(precedence int, lhs ast.Expression) ast.Expression {
 	defer p.tr.Trace("parseInfixExpression")()
 	expr := &ast.Infix{
@@ -185,56 +423,212 @@ func (p *parser) parseInfixExpression(precedence int, lhs ast.Expression) ast.Ex
 	return expr
 }
 
+func (p *parser) parseVariable() ast.Expression {
+	defer p.tr.Trace("parseVariable")()
+	return &ast.Variable{
+		Token: p.curr,
+		Name:  p.curr.Text,
+	}
+}
+
+func (p *parser) parseAssign(precedence int, lhs ast.Expression) ast.Expression {
+	defer p.tr.Trace("parseAssign")()
+	variable, ok := lhs.(*ast.Variable)
+	if !ok {
+		panic(&errors.ParseError{
+			Span: p.curr.Span,
+			Node: lhs,
+			Err:  fmt.Errorf("can't assign to %s, expected a variable", lhs),
+		})
+	}
+	expr := &ast.Assign{
+		Token: p.curr,
+		Op:    p.curr.Text,
+		Var:   variable,
+	}
+	p.advance()
+	expr.Value = p.parseExpression(precedence)
+	return expr
+}
+
 func (p *parser) parseIdentifier() ast.Expression {
 	defer p.tr.Trace("parseIdentifier")()
-	return &ast.Field{
+	return &ast.Identifier{
 		Token: p.curr,
 		Name:  p.curr.Text,
 	}
 }
 
+// parseCall parses the arguments to fn, which are all the operands that
+// follow it.
+func (p *parser) parseCall(fn ast.Expression) ast.Expression {
+	defer p.tr.Trace("parseCall")()
+	call := &ast.Call{
+		Token: p.curr,
+		Fn:    fn,
+	}
+	for startsOperand(p.next.Ttype) {
+		p.advance()
+		call.Args = append(call.Args, p.parseExpression(PrecedenceCall))
+	}
+	return call
+}
+
+// parseSelector parses a field selected from the expression before it, e.g.
+// the `.B` in `.A.B`. Consecutive selectors are collected in one chain.
+func (p *parser) parseSelector(precedence int, lhs ast.Expression) ast.Expression {
+	defer p.tr.Trace("parseSelector")()
+	chain, ok := lhs.(*ast.Chain)
+	if !ok {
+		chain = &ast.Chain{
+			Token: p.curr,
+			Node:  lhs,
+		}
+	}
+	p.advance()
+	p.expectToken(token.IDENT, "(field name after '.')")
+	chain.Fields = append(chain.Fields, &ast.Field{
+		Token: p.curr,
+		Name:  p.curr.Text,
+	})
+	return chain
+}
+
+// parseIndex parses an index or slice expression, e.g. `.Items[0]` or
+// `.Name[1:4]`. Both bounds of a slice are optional.
+func (p *parser) parseIndex(precedence int, lhs ast.Expression) ast.Expression {
+	defer p.tr.Trace("parseIndex")()
+	tok := p.curr
+	p.advance()
+	var low ast.Expression
+	if p.curr.Ttype != token.COLON {
+		low = p.parseExpression(PrecedenceLowest)
+		p.advance()
+	}
+	if p.curr.Ttype == token.RBRACKET && low != nil {
+		return &ast.Index{
+			Token: tok,
+			Node:  lhs,
+			Index: low,
+		}
+	}
+	p.expectToken(token.COLON, "(missing index?)")
+	p.advance()
+	var high ast.Expression
+	if p.curr.Ttype != token.RBRACKET {
+		high = p.parseExpression(PrecedenceLowest)
+		p.advance()
+	}
+	p.expectToken(token.RBRACKET, "(missing closing bracket?)")
+	return &ast.Slice{
+		Token: tok,
+		Node:  lhs,
+		Low:   low,
+		High:  high,
+	}
+}
+
+// parseSignedNumber parses a signed number that follows an operand, e.g.
+// `.A -1`, which is the same as `.A - 1`.
+func (p *parser) parseSignedNumber(precedence int, lhs ast.Expression) ast.Expression {
+	defer p.tr.Trace("parseSignedNumber")()
+	expr := &ast.Infix{
+		Token: p.curr,
+		Op:    p.curr.Text[:1],
+		Lhs:   lhs,
+	}
+	p.curr.Text = p.curr.Text[1:]
+	expr.Rhs = p.parseExpression(precedence)
+	return expr
+}
+
+func (p *parser) parsePipeline(precedence int, lhs ast.Expression) ast.Expression {
+	defer p.tr.Trace("parsePipeline")()
+	pipe, ok := lhs.(*ast.Pipeline)
+	if !ok {
+		pipe = &ast.Pipeline{
+			Token: p.curr,
+			Cmds:  []ast.Expression{lhs},
+		}
+	}
+	p.advance()
+	pipe.Cmds = append(pipe.Cmds, p.parseExpression(precedence))
+	return pipe
+}
+
+// parseNumber parses an integer or floating point literal, using Go's
+// syntax, e.g. 0x1F, 0o17, 0b101, 1_000, 1.5 or 1e9.
 func (p *parser) parseNumber() ast.Expression {
 	defer p.tr.Trace("parseNumber")()
-	value, err := strconv.Atoi(p.curr.Text)
+	value, err := strconv.ParseInt(p.curr.Text, 0, 64)
+	if err == nil {
+		return &ast.Number{
+			Token: p.curr,
+			Value: value,
+		}
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		// too big for an int64, but it may still fit in a uint64
+		if value, err := strconv.ParseUint(p.curr.Text, 0, 64); err == nil {
+			return &ast.Uint{
+				Token: p.curr,
+				Value: value,
+			}
+		}
+		panic(fmt.Errorf("parseNumber: %w: %s", errors.ErrOverflow, p.curr.Text))
+	}
+	float, err := strconv.ParseFloat(p.curr.Text, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		panic(fmt.Errorf("parseNumber: %w: %s", errors.ErrOverflow, p.curr.Text))
+	}
 	if err != nil {
 		panic(fmt.Errorf("parseNumber: not a number: %q", p.curr.Text))
 	}
-	return &ast.Number{
+	return &ast.Float{
+		Token: p.curr,
+		Value: float,
+	}
+}
+
+func (p *parser) parseString() ast.Expression {
+	defer p.tr.Trace("parseString")()
+	value, err := strconv.Unquote(p.curr.Text)
+	if err != nil {
+		panic(fmt.Errorf("parseString: invalid string %s: %w", p.curr.Text, err))
+	}
+	return &ast.String{
 		Token: p.curr,
 		Value: value,
 	}
 }
 
-// Parse returns the next expression
-func (p *parser) Parse() (prog *ast.Program, err error) {
+// parseChar parses a character literal such as 'a', which is a number just
+// like in Go.
+func (p *parser) parseChar() ast.Expression {
+	defer p.tr.Trace("parseChar")()
+	value, _, tail, err := strconv.UnquoteChar(p.curr.Text[1:], '\'')
+	if err != nil || tail != "'" {
+		panic(fmt.Errorf("parseChar: invalid character %s", p.curr.Text))
+	}
+	return &ast.Number{
+		Token: p.curr,
+		Value: int64(value),
+	}
+}
+
+// Parse parses the whole template. On syntax errors, it carries on after
+// each of them, and returns all of them as an errors.ErrorList, along with
+// the program without the broken actions.
+func (p *parser) Parse() (*ast.Program, error) {
 	if p.curr.Ttype == token.EOF {
 		return nil, errors.ErrNoTokens
 	}
 
-	// on errors, the parser panics, and we catch it here.
-	defer func() {
-		r := recover()
-		if e, ok := r.(error); ok {
-			err = e
-		} else if r != nil {
-			err = fmt.Errorf("%v", r)
-		}
-	}()
-
-	// precedence := tokenPrecedence(p.curr.Ttype)
-	// fmt.Printf("precedence for %q is %d\n", p.curr.Ttype, precedence)
-
-	var res ast.Program
-	var it int
-	for p.curr.Ttype != token.EOF {
-		expr := p.parseExpression(PrecedenceLowest)
-		p.advance()
-		res.Exprs = append(res.Exprs, expr)
-		it++
-		if it > 5 {
-			break
-		}
+	prog := &ast.Program{
+		List: *p.parseList(),
+	}
+	if len(p.errs) > 0 {
+		return prog, p.errs
 	}
-	prog = &res
-	return
+	return prog, nil
 }
@@ -1,10 +1,13 @@
 package parser
 
 import (
+	"math"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/kvalv/template-mvp/ast"
+	"github.com/kvalv/template-mvp/errors"
 	"github.com/kvalv/template-mvp/lex"
 	"github.com/kvalv/template-mvp/token"
 )
@@ -71,9 +74,65 @@ func TestParser(t *testing.T) {
 					If: &ast.Number{
 						Value: 1,
 					},
-					Body: &ast.Text{
-						Text: "hi",
+					Body: &ast.List{Exprs: []ast.Expression{
+						&ast.Text{Text: "hi"},
+					}},
+				}},
+		},
+		{
+			descr: "cond/else",
+			input: lex.New("{{if 1}}hi{{else}}bye{{end}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Cond{
+					If:   &ast.Number{Value: 1},
+					Body: list(&ast.Text{Text: "hi"}),
+					Else: list(&ast.Text{Text: "bye"}),
+				}},
+		},
+		{
+			descr: "cond/else if",
+			input: lex.New("{{if 1}}a{{else if 2}}b{{else if 3}}c{{else}}d{{end}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Cond{
+					If:   &ast.Number{Value: 1},
+					Body: list(&ast.Text{Text: "a"}),
+					Else: &ast.Cond{
+						If:   &ast.Number{Value: 2},
+						Body: list(&ast.Text{Text: "b"}),
+						Else: &ast.Cond{
+							If:   &ast.Number{Value: 3},
+							Body: list(&ast.Text{Text: "c"}),
+							Else: list(&ast.Text{Text: "d"}),
+						},
+					},
+				}},
+		},
+		{
+			descr: "cond/body with text and actions",
+			input: lex.New("{{if .X}}Hello {{.Name}}!{{end}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Cond{
+					If: &ast.Prefix{
+						Op:  ".",
+						Rhs: &ast.Field{Name: "X"},
 					},
+					Body: list(
+						&ast.Text{Text: "Hello "},
+						&ast.Action{Body: &ast.Prefix{
+							Op:  ".",
+							Rhs: &ast.Field{Name: "Name"},
+						}},
+						&ast.Text{Text: "!"},
+					),
+				}},
+		},
+		{
+			descr: "cond/empty body",
+			input: lex.New("{{if 1}}{{end}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Cond{
+					If:   &ast.Number{Value: 1},
+					Body: list(),
 				}},
 		},
 		{
@@ -91,6 +150,439 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			descr: "dot",
+			input: lex.New("{{.}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Dot{},
+			},
+		},
+		{
+			descr: "range",
+			input: lex.New("{{range .Items}}{{.}}{{end}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Range{
+					Pipe: &ast.Prefix{
+						Op:  ".",
+						Rhs: &ast.Field{Name: "Items"},
+					},
+					Body: list(&ast.Action{
+						Body: &ast.Dot{},
+					}),
+				},
+			},
+		},
+		{
+			descr: "range/else",
+			input: lex.New("{{range .Items}}x{{else}}y{{end}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Range{
+					Pipe: &ast.Prefix{
+						Op:  ".",
+						Rhs: &ast.Field{Name: "Items"},
+					},
+					Body: list(&ast.Text{Text: "x"}),
+					Else: list(&ast.Text{Text: "y"}),
+				},
+			},
+		},
+		{
+			descr: "with/else",
+			input: lex.New("{{with .Customer}}x{{else}}y{{end}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.With{
+					Pipe: &ast.Prefix{
+						Op:  ".",
+						Rhs: &ast.Field{Name: "Customer"},
+					},
+					Body: list(&ast.Text{Text: "x"}),
+					Else: list(&ast.Text{Text: "y"}),
+				},
+			},
+		},
+		{
+			descr: "declare",
+			input: lex.New("{{$x := 1 + 2}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Assign{
+					Op:  ":=",
+					Var: &ast.Variable{Name: "$x"},
+					Value: &ast.Infix{
+						Lhs: &ast.Number{Value: 1},
+						Op:  "+",
+						Rhs: &ast.Number{Value: 2},
+					},
+				},
+			},
+		},
+		{
+			descr: "assign",
+			input: lex.New("{{$x = $}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Assign{
+					Op:    "=",
+					Var:   &ast.Variable{Name: "$x"},
+					Value: &ast.Variable{Name: "$"},
+				},
+			},
+		},
+		{
+			descr: "range/variables",
+			input: lex.New("{{range $i, $e := .}}{{$e}}{{end}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Range{
+					Key:   &ast.Variable{Name: "$i"},
+					Value: &ast.Variable{Name: "$e"},
+					Pipe:  &ast.Dot{},
+					Body: list(&ast.Action{
+						Body: &ast.Variable{Name: "$e"},
+					}),
+				},
+			},
+		},
+		{
+			descr: "call",
+			input: lex.New("{{truncate 40 .Title}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Call{
+					Fn: &ast.Identifier{Name: "truncate"},
+					Args: []ast.Expression{
+						&ast.Number{Value: 40},
+						&ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Title"}},
+					},
+				},
+			},
+		},
+		{
+			descr: "call/arguments bind tighter than infix",
+			input: lex.New("{{len .Items + 1}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Call{
+						Fn: &ast.Identifier{Name: "len"},
+						Args: []ast.Expression{
+							&ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Items"}},
+						},
+					},
+					Op:  "+",
+					Rhs: &ast.Number{Value: 1},
+				},
+			},
+		},
+		{
+			descr: "pipeline",
+			input: lex.New("{{.Title | upper | truncate 40}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Pipeline{
+					Cmds: []ast.Expression{
+						&ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Title"}},
+						&ast.Identifier{Name: "upper"},
+						&ast.Call{
+							Fn:   &ast.Identifier{Name: "truncate"},
+							Args: []ast.Expression{&ast.Number{Value: 40}},
+						},
+					},
+				},
+			},
+		},
+		{
+			descr: "string",
+			input: lex.New(`{{"a\tb}}"}}`, os.Stderr),
+			want: &ast.Action{
+				Body: &ast.String{Value: "a\tb}}"},
+			},
+		},
+		{
+			descr: "raw string",
+			input: lex.New("{{`a\\tb`}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.String{Value: `a\tb`},
+			},
+		},
+		{
+			descr: "char",
+			input: lex.New(`{{'\n'}}`, os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Number{Value: '\n'},
+			},
+		},
+		{
+			descr: "call with string argument",
+			input: lex.New(`{{printf "%d" 1}}`, os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Call{
+					Fn: &ast.Identifier{Name: "printf"},
+					Args: []ast.Expression{
+						&ast.String{Value: "%d"},
+						&ast.Number{Value: 1},
+					},
+				},
+			},
+		},
+		{
+			descr: "mul binds tighter than plus",
+			input: lex.New("{{1 + 2 * 3}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Number{Value: 1},
+					Op:  "+",
+					Rhs: &ast.Infix{
+						Lhs: &ast.Number{Value: 2},
+						Op:  "*",
+						Rhs: &ast.Number{Value: 3},
+					},
+				},
+			},
+		},
+		{
+			descr: "mul is left associative",
+			input: lex.New("{{8 / 4 % 3}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Infix{
+						Lhs: &ast.Number{Value: 8},
+						Op:  "/",
+						Rhs: &ast.Number{Value: 4},
+					},
+					Op:  "%",
+					Rhs: &ast.Number{Value: 3},
+				},
+			},
+		},
+		{
+			descr: "parentheses",
+			input: lex.New("{{(1 + 2) * 3}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Infix{
+						Lhs: &ast.Number{Value: 1},
+						Op:  "+",
+						Rhs: &ast.Number{Value: 2},
+					},
+					Op:  "*",
+					Rhs: &ast.Number{Value: 3},
+				},
+			},
+		},
+		{
+			descr: "parenthesized call argument",
+			input: lex.New("{{printf (len .A) 1}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Call{
+					Fn: &ast.Identifier{Name: "printf"},
+					Args: []ast.Expression{
+						&ast.Call{
+							Fn: &ast.Identifier{Name: "len"},
+							Args: []ast.Expression{
+								&ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+							},
+						},
+						&ast.Number{Value: 1},
+					},
+				},
+			},
+		},
+		{
+			descr: "boolean precedence",
+			input: lex.New("{{.Admin || .Owner && !.Locked}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Admin"}},
+					Op:  "||",
+					Rhs: &ast.Infix{
+						Lhs: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Owner"}},
+						Op:  "&&",
+						Rhs: &ast.Prefix{
+							Op:  "!",
+							Rhs: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Locked"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			descr: "comparison binds tighter than &&",
+			input: lex.New("{{1 + 1 > 1 && 2 < 3}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Infix{
+						Lhs: &ast.Infix{
+							Lhs: &ast.Number{Value: 1},
+							Op:  "+",
+							Rhs: &ast.Number{Value: 1},
+						},
+						Op:  ">",
+						Rhs: &ast.Number{Value: 1},
+					},
+					Op: "&&",
+					Rhs: &ast.Infix{
+						Lhs: &ast.Number{Value: 2},
+						Op:  "<",
+						Rhs: &ast.Number{Value: 3},
+					},
+				},
+			},
+		},
+		{
+			descr: "comparison with nil",
+			input: lex.New("{{.A != nil && .B >= 2}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Infix{
+						Lhs: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+						Op:  "!=",
+						Rhs: &ast.Nil{},
+					},
+					Op: "&&",
+					Rhs: &ast.Infix{
+						Lhs: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "B"}},
+						Op:  ">=",
+						Rhs: &ast.Number{Value: 2},
+					},
+				},
+			},
+		}, {
+			descr: "unary minus binds tighter than mul",
+			input: lex.New("{{-.A * 2}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Prefix{
+						Op:  "-",
+						Rhs: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+					},
+					Op:  "*",
+					Rhs: &ast.Number{Value: 2},
+				},
+			},
+		},
+		{
+			descr: "negative argument",
+			input: lex.New("{{add .A -1}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Call{
+					Fn: &ast.Identifier{Name: "add"},
+					Args: []ast.Expression{
+						&ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+						&ast.Number{Value: -1},
+					},
+				},
+			},
+		},
+		{
+			descr: "number literals",
+			input: lex.New("{{0x1F + 1.5e3 * 1_000}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Number{Value: 31},
+					Op:  "+",
+					Rhs: &ast.Infix{
+						Lhs: &ast.Float{Value: 1500},
+						Op:  "*",
+						Rhs: &ast.Number{Value: 1000},
+					},
+				},
+			},
+		},
+		{
+			descr: "unsigned literal",
+			input: lex.New("{{18446744073709551615}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Uint{Value: math.MaxUint64},
+			},
+		},
+		{
+			descr: "field chain",
+			input: lex.New("{{-.A.B.C}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Prefix{
+					Op: "-",
+					Rhs: &ast.Chain{
+						Node:   &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+						Fields: []*ast.Field{{Name: "B"}, {Name: "C"}},
+					},
+				},
+			},
+		},
+		{
+			descr: "field chain as argument",
+			input: lex.New("{{len $x.Items .A}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Call{
+					Fn: &ast.Identifier{Name: "len"},
+					Args: []ast.Expression{
+						&ast.Chain{
+							Node:   &ast.Variable{Name: "$x"},
+							Fields: []*ast.Field{{Name: "Items"}},
+						},
+						&ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+					},
+				},
+			},
+		},
+		{
+			descr: "index and selector",
+			input: lex.New("{{.Items[.I + 1].Name}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Chain{
+					Node: &ast.Index{
+						Node: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Items"}},
+						Index: &ast.Infix{
+							Lhs: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "I"}},
+							Op:  "+",
+							Rhs: &ast.Number{Value: 1},
+						},
+					},
+					Fields: []*ast.Field{{Name: "Name"}},
+				},
+			},
+		},
+		{
+			descr: "slice with optional bounds",
+			input: lex.New("{{len .Name[:2]}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Call{
+					Fn: &ast.Identifier{Name: "len"},
+					Args: []ast.Expression{
+						&ast.Slice{
+							Node: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Name"}},
+							High: &ast.Number{Value: 2},
+						},
+					},
+				},
+			},
+		},
+		{
+			descr: "method call",
+			input: lex.New(`{{.Price.Format "EUR" .A -1}}`, os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Call{
+					Fn: &ast.Chain{
+						Node:   &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Price"}},
+						Fields: []*ast.Field{{Name: "Format"}},
+					},
+					Args: []ast.Expression{
+						&ast.String{Value: "EUR"},
+						&ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+						&ast.Number{Value: -1},
+					},
+				},
+			},
+		},
+		{
+			descr: "signed number after operand is a sum",
+			input: lex.New("{{.A -1 * 2}}", os.Stderr),
+			want: &ast.Action{
+				Body: &ast.Infix{
+					Lhs: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+					Op:  "-",
+					Rhs: &ast.Infix{
+						Lhs: &ast.Number{Value: 1},
+						Op:  "*",
+						Rhs: &ast.Number{Value: 2},
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range cases {
@@ -117,21 +609,104 @@ func expectExpression(t *testing.T, want, got ast.Expression) {
 		expectPrefix(t, want, got)
 	case *ast.Number:
 		expectNumber(t, want, got)
+	case *ast.Uint:
+		expectUint(t, want, got)
+	case *ast.Float:
+		expectFloat(t, want, got)
 	case *ast.Field:
 		expectField(t, want, got)
 	case *ast.Infix:
 		expectInfix(t, want, got)
 	case *ast.Text:
 		expectText(t, want, got)
+	case *ast.String:
+		expectString(t, want, got)
 	case *ast.Action:
 		expectAction(t, want, got)
 	case *ast.Cond:
 		expectCond(t, want, got)
+	case *ast.Range:
+		expectRange(t, want, got)
+	case *ast.List:
+		expectList(t, want, got)
+	case *ast.With:
+		expectWith(t, want, got)
+	case *ast.Variable:
+		expectVariable(t, want, got)
+	case *ast.Identifier:
+		expectIdentifier(t, want, got)
+	case *ast.Call:
+		expectCall(t, want, got)
+	case *ast.Pipeline:
+		expectPipeline(t, want, got)
+	case *ast.Assign:
+		expectAssign(t, want, got)
+	case *ast.Dot:
+		if _, ok := got.(*ast.Dot); !ok {
+			t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+		}
+	case *ast.Nil:
+		if _, ok := got.(*ast.Nil); !ok {
+			t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+		}
+	case *ast.Chain:
+		expectChain(t, want, got)
+	case *ast.Index:
+		expectIndex(t, want, got)
+	case *ast.Slice:
+		expectSlice(t, want, got)
 	default:
 		t.Fatalf("unexpected type: %T", want)
 	}
 }
 
+func expectIndex(t *testing.T, want *ast.Index, got ast.Expression) {
+	t.Helper()
+	index, ok := got.(*ast.Index)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	expectExpression(t, want.Node, index.Node)
+	expectExpression(t, want.Index, index.Index)
+}
+
+func expectSlice(t *testing.T, want *ast.Slice, got ast.Expression) {
+	t.Helper()
+	slice, ok := got.(*ast.Slice)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	expectExpression(t, want.Node, slice.Node)
+	expectOptional(t, want.Low, slice.Low)
+	expectOptional(t, want.High, slice.High)
+}
+
+func expectOptional(t *testing.T, want, got ast.Expression) {
+	t.Helper()
+	if want == nil {
+		if got != nil {
+			t.Fatalf("expected nil, got=%s", got)
+		}
+		return
+	}
+	expectExpression(t, want, got)
+}
+
+func expectChain(t *testing.T, want *ast.Chain, got ast.Expression) {
+	t.Helper()
+	chain, ok := got.(*ast.Chain)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	expectExpression(t, want.Node, chain.Node)
+	if len(chain.Fields) != len(want.Fields) {
+		t.Fatalf("number of fields mismatch; want=%d, got=%d", len(want.Fields), len(chain.Fields))
+	}
+	for i := range want.Fields {
+		expectField(t, want.Fields[i], chain.Fields[i])
+	}
+}
+
 func expectPrefix(t *testing.T, want *ast.Prefix, got ast.Expression) {
 	t.Helper()
 	prefix, ok := got.(*ast.Prefix)
@@ -158,8 +733,140 @@ func expectCond(t *testing.T, want *ast.Cond, got ast.Expression) {
 	if !ok {
 		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
 	}
-	expectExpression(t, want.If, cond.If)
+	// the condition is missing when it has a syntax error
+	expectOptional(t, want.If, cond.If)
 	expectExpression(t, want.Body, cond.Body)
+	if want.Else == nil {
+		if cond.Else != nil {
+			t.Fatalf("unexpected else branch: %s", cond.Else)
+		}
+		return
+	}
+	expectExpression(t, want.Else, cond.Else)
+}
+
+func expectList(t *testing.T, want *ast.List, got ast.Expression) {
+	t.Helper()
+	list, ok := got.(*ast.List)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	if len(list.Exprs) != len(want.Exprs) {
+		t.Fatalf("length mismatch; want=%d, got=%d (%s)", len(want.Exprs), len(list.Exprs), list)
+	}
+	for i := range want.Exprs {
+		expectExpression(t, want.Exprs[i], list.Exprs[i])
+	}
+}
+
+func expectRange(t *testing.T, want *ast.Range, got ast.Expression) {
+	t.Helper()
+	rng, ok := got.(*ast.Range)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	expectOptionalVariable(t, want.Key, rng.Key)
+	expectOptionalVariable(t, want.Value, rng.Value)
+	expectExpression(t, want.Pipe, rng.Pipe)
+	expectExpression(t, want.Body, rng.Body)
+	if want.Else == nil {
+		if rng.Else != nil {
+			t.Fatalf("unexpected else branch: %s", rng.Else)
+		}
+		return
+	}
+	expectExpression(t, want.Else, rng.Else)
+}
+
+func expectWith(t *testing.T, want *ast.With, got ast.Expression) {
+	t.Helper()
+	with, ok := got.(*ast.With)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	expectExpression(t, want.Pipe, with.Pipe)
+	expectExpression(t, want.Body, with.Body)
+	if want.Else == nil {
+		if with.Else != nil {
+			t.Fatalf("unexpected else branch: %s", with.Else)
+		}
+		return
+	}
+	expectExpression(t, want.Else, with.Else)
+}
+
+func expectVariable(t *testing.T, want *ast.Variable, got ast.Expression) {
+	t.Helper()
+	variable, ok := got.(*ast.Variable)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	if variable.Name != want.Name {
+		t.Fatalf("name mismatch; want=%q, got=%q", want.Name, variable.Name)
+	}
+}
+func expectOptionalVariable(t *testing.T, want, got *ast.Variable) {
+	t.Helper()
+	if want == nil {
+		if got != nil {
+			t.Fatalf("unexpected variable: %s", got)
+		}
+		return
+	}
+	if got == nil {
+		t.Fatalf("missing variable %s", want)
+	}
+	expectVariable(t, want, got)
+}
+func expectAssign(t *testing.T, want *ast.Assign, got ast.Expression) {
+	t.Helper()
+	assign, ok := got.(*ast.Assign)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	if assign.Op != want.Op {
+		t.Fatalf("op mismatch; want=%q, got=%q", want.Op, assign.Op)
+	}
+	expectVariable(t, want.Var, assign.Var)
+	expectExpression(t, want.Value, assign.Value)
+}
+
+func expectIdentifier(t *testing.T, want *ast.Identifier, got ast.Expression) {
+	t.Helper()
+	ident, ok := got.(*ast.Identifier)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	if ident.Name != want.Name {
+		t.Fatalf("name mismatch; want=%q, got=%q", want.Name, ident.Name)
+	}
+}
+func expectCall(t *testing.T, want *ast.Call, got ast.Expression) {
+	t.Helper()
+	call, ok := got.(*ast.Call)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	expectExpression(t, want.Fn, call.Fn)
+	if len(call.Args) != len(want.Args) {
+		t.Fatalf("argument count mismatch; want=%d, got=%d (%s)", len(want.Args), len(call.Args), call)
+	}
+	for i := range want.Args {
+		expectExpression(t, want.Args[i], call.Args[i])
+	}
+}
+func expectPipeline(t *testing.T, want *ast.Pipeline, got ast.Expression) {
+	t.Helper()
+	pipe, ok := got.(*ast.Pipeline)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	if len(pipe.Cmds) != len(want.Cmds) {
+		t.Fatalf("command count mismatch; want=%d, got=%d (%s)", len(want.Cmds), len(pipe.Cmds), pipe)
+	}
+	for i := range want.Cmds {
+		expectExpression(t, want.Cmds[i], pipe.Cmds[i])
+	}
 }
 
 func expectNumber(t *testing.T, want *ast.Number, got ast.Expression) {
@@ -172,6 +879,26 @@ func expectNumber(t *testing.T, want *ast.Number, got ast.Expression) {
 		t.Fatalf("value mismatch; want=%d, got=%d", want.Value, number.Value)
 	}
 }
+func expectUint(t *testing.T, want *ast.Uint, got ast.Expression) {
+	t.Helper()
+	number, ok := got.(*ast.Uint)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	if number.Value != want.Value {
+		t.Fatalf("value mismatch; want=%d, got=%d", want.Value, number.Value)
+	}
+}
+func expectFloat(t *testing.T, want *ast.Float, got ast.Expression) {
+	t.Helper()
+	float, ok := got.(*ast.Float)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	if float.Value != want.Value {
+		t.Fatalf("value mismatch; want=%g, got=%g", want.Value, float.Value)
+	}
+}
 func expectField(t *testing.T, want *ast.Field, got ast.Expression) {
 	t.Helper()
 	field, ok := got.(*ast.Field)
@@ -204,3 +931,135 @@ func expectText(t *testing.T, want *ast.Text, got ast.Expression) {
 		t.Fatalf("text mismatch; want=%q, got=%q", want.Text, text.Text)
 	}
 }
+
+func list(exprs ...ast.Expression) *ast.List {
+	return &ast.List{Exprs: exprs}
+}
+func expectString(t *testing.T, want *ast.String, got ast.Expression) {
+	t.Helper()
+	str, ok := got.(*ast.String)
+	if !ok {
+		t.Fatalf("type mismatch; want=%T, got=%T", want, got)
+	}
+	if want.Value != str.Value {
+		t.Fatalf("value mismatch; want=%q, got=%q", want.Value, str.Value)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "a\n{{if .Ok}}{{.A.B + 1}}{{end}}"
+	prog, err := New(lex.New(input, os.Stderr), os.Stderr).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %s", err)
+	}
+	at := func(row, col int) token.Position {
+		return token.Position{Row: row, Col: col}
+	}
+	expectPosition := func(descr string, got, want token.Position) {
+		t.Helper()
+		if got != want {
+			t.Fatalf("%s: position mismatch; want=%s, got=%s", descr, want, got)
+		}
+	}
+
+	text := prog.Exprs[0].(*ast.Text)
+	expectPosition("text", text.Start, at(1, 1))
+	action := prog.Exprs[1].(*ast.Action)
+	expectPosition("action", action.Start, at(2, 1))
+	cond := action.Body.(*ast.Cond)
+	expectPosition("if", cond.Start, at(2, 3))
+	expectPosition("if/field", cond.If.(*ast.Prefix).Rhs.(*ast.Field).Start, at(2, 7))
+	infix := cond.Body.Exprs[0].(*ast.Action).Body.(*ast.Infix)
+	expectPosition("infix", infix.Start, at(2, 18))
+	chain := infix.Lhs.(*ast.Chain)
+	expectPosition("chain", chain.Start, at(2, 15))
+	expectPosition("chain/field", chain.Fields[0].Start, at(2, 16))
+	expectPosition("number", infix.Rhs.(*ast.Number).Start, at(2, 20))
+}
+
+func TestRecovery(t *testing.T) {
+	input := "{{.A +}} ok {{if (.B}}yes{{end}}\n{{1 2}} {{.C}}{{@}}"
+	prog, err := New(lex.New(input, os.Stderr), os.Stderr).Parse()
+
+	var errs errors.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected an ErrorList, got %T: %v", err, err)
+	}
+	want := []token.Position{{Row: 1, Col: 7}, {Row: 1, Col: 21}, {Row: 2, Col: 5}, {Row: 2, Col: 17}}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(errs), errs.Unwrap())
+	}
+	for i, pos := range want {
+		if errs[i].Span.Start != pos {
+			t.Fatalf("error %d: position mismatch; want=%s, got=%s (%s)", i, pos, errs[i].Span.Start, errs[i])
+		}
+	}
+
+	// the program still has everything that could be parsed
+	expectList(t, list(
+		&ast.Text{Text: " ok "},
+		&ast.Action{Body: &ast.Cond{Body: list(&ast.Text{Text: "yes"})}},
+		&ast.Text{Text: "\n"},
+		&ast.Text{Text: " "},
+		&ast.Action{Body: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "C"}}},
+	), &prog.List)
+}
+
+func TestRecoveryBeforeBlock(t *testing.T) {
+	cases := []struct {
+		descr string
+		input string
+		want  *ast.List
+	}{
+		{
+			descr: "block",
+			input: "Hi {{.Name {{if .Name}}x{{end}}",
+			want: list(
+				&ast.Text{Text: "Hi "},
+				&ast.Action{Body: &ast.Cond{
+					If:   &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Name"}},
+					Body: list(&ast.Text{Text: "x"}),
+				}},
+			),
+		},
+		{
+			descr: "else",
+			input: "{{if .A}}{{.B {{else}}y{{end}}",
+			want: list(
+				&ast.Action{Body: &ast.Cond{
+					If:   &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "A"}},
+					Body: list(),
+					Else: list(&ast.Text{Text: "y"}),
+				}},
+			),
+		},
+		{
+			descr: "header",
+			input: "{{range .Items {{.}}{{end}}",
+			want: list(
+				&ast.Action{Body: &ast.Range{
+					Pipe: &ast.Prefix{Op: ".", Rhs: &ast.Field{Name: "Items"}},
+					Body: list(&ast.Action{Body: &ast.Dot{}}),
+				}},
+			),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			prog, err := New(lex.New(tc.input, os.Stderr), os.Stderr).Parse()
+			var errs errors.ErrorList
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected an ErrorList, got %T: %v", err, err)
+			}
+			// only the missing }}, nothing about the block
+			if len(errs) != 1 {
+				t.Fatalf("expected 1 error, got %d: %v", len(errs), errs.Unwrap())
+			}
+			if !strings.Contains(errs[0].Error(), "unterminated action starting at") {
+				t.Fatalf("unexpected error: %s", errs[0])
+			}
+			expectList(t, tc.want, &prog.List)
+		})
+	}
+}
@@ -7,18 +7,65 @@ import (
 const (
 	_ int = iota
 	PrecedenceLowest
+	PrecedenceAssign
+	PrecedencePipe
+	PrecedenceOr
+	PrecedenceAnd
+	PrecedenceCompare
 	PrecedencePlus
 	PrecedenceMul
+	// function arguments bind tighter than any infix operator, so
+	// `len .Items + 1` is `(len .Items) + 1`.
+	PrecedenceCall
 	PrecedencePrefix
+	// field selectors and indexing bind tightest, so `-.A.B` is
+	// `-(.A.B)`
+	PrecedencePostfix
 )
 
 func tokenPrecedence(ttype token.TokenType) (p int) {
 	switch ttype {
-	case token.DOT:
-		return PrecedencePrefix
-	case token.PLUS, token.MINUS, token.GT, token.LT, token.EQ:
+	case token.DECLARE, token.ASSIGN:
+		return PrecedenceAssign
+	case token.PIPE:
+		return PrecedencePipe
+	case token.OR:
+		return PrecedenceOr
+	case token.AND:
+		return PrecedenceAnd
+	case token.GT, token.LT, token.EQ, token.NOT_EQ, token.LT_EQ, token.GT_EQ:
+		return PrecedenceCompare
+	case token.PLUS, token.MINUS:
 		return PrecedencePlus
+	case token.ASTERISK, token.SLASH, token.PERCENT:
+		return PrecedenceMul
+	case token.SELECTOR, token.LBRACKET:
+		return PrecedencePostfix
 	default:
 		return PrecedenceLowest
 	}
 }
+
+// infixPrecedence returns the precedence of tk as an infix operator. A
+// signed number, like the `-1` in `.A -1`, is a sum in this position.
+func infixPrecedence(tk token.Token) int {
+	if isSigned(tk) {
+		return PrecedencePlus
+	}
+	return tokenPrecedence(tk.Ttype)
+}
+
+func isSigned(tk token.Token) bool {
+	return tk.Ttype == token.NUMBER && (tk.Text[0] == '-' || tk.Text[0] == '+')
+}
+
+// startsOperand reports whether a token of the given type can start an
+// argument to a function call.
+func startsOperand(ttype token.TokenType) bool {
+	switch ttype {
+	case token.DOT, token.IDENT, token.VARIABLE, token.NUMBER, token.STRING, token.CHAR, token.TRUE, token.FALSE, token.NIL, token.LPAREN, token.BANG:
+		return true
+	default:
+		return false
+	}
+}
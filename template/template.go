@@ -14,12 +14,29 @@ import (
 )
 
 type template struct {
-	logdest io.Writer
-	lexer   lex.Lexer
+	name       string
+	source     string
+	logdest    io.Writer
+	lexer      lex.Lexer
+	funcs      FuncMap
+	missingKey MissingKeyPolicy
 }
 
 type Options func(*template)
 
+// FuncMap maps names to functions that can be called from the template. Each
+// function must return a single value, or a value and an error. A non-nil
+// error stops the execution.
+type FuncMap map[string]any
+
+// the name of the template, e.g. its file name, which prefixes the position
+// in errors: `invoice.tmpl:12:7: field not found: Totl`
+func Name(name string) Options {
+	return func(t *template) {
+		t.name = name
+	}
+}
+
 // where to write logs
 func LogDest(w io.Writer) Options {
 	return func(t *template) {
@@ -27,9 +44,45 @@ func LogDest(w io.Writer) Options {
 	}
 }
 
+// functions that can be called from the template
+func Funcs(funcs FuncMap) Options {
+	return func(t *template) {
+		for name, fn := range funcs {
+			t.funcs[name] = fn
+		}
+	}
+}
+
+// MissingKeyPolicy decides what a field, map key or method that doesn't
+// exist evaluates to, e.g. `.Email` when there is no such field.
+type MissingKeyPolicy = object.MissingKey
+
+var (
+	// MissingKeyError stops the execution with an error. This is the
+	// default.
+	MissingKeyError = MissingKeyPolicy{Mode: object.MissingKeyError}
+	// MissingKeyZero evaluates to the zero value of a map's element type,
+	// and to nil for structs and methods.
+	MissingKeyZero = MissingKeyPolicy{Mode: object.MissingKeyZero}
+)
+
+// MissingKeyDefault evaluates to the given placeholder.
+func MissingKeyDefault(placeholder string) MissingKeyPolicy {
+	return MissingKeyPolicy{Mode: object.MissingKeyDefault, Placeholder: placeholder}
+}
+
+// what missing fields, map keys and methods evaluate to
+func MissingKey(policy MissingKeyPolicy) Options {
+	return func(t *template) {
+		t.missingKey = policy
+	}
+}
+
 func New(input string, opts ...Options) *template {
 	t := &template{
+		source:  input,
 		logdest: io.Discard,
+		funcs:   make(FuncMap),
 	}
 	for _, opt := range opts {
 		opt(t)
@@ -53,18 +106,47 @@ func (t *template) Execute(v any) (string, error) {
 	parser := parser.New(t.lexer, t.logdest)
 	prog, err := parser.Parse()
 	if err != nil {
-		return "", err
+		return "", t.describe(err)
+	}
+	env := object.NewEnvironment(v)
+	env.SetMissingKey(t.missingKey)
+	for name, fn := range t.funcs {
+		if err := env.DefineFunc(name, fn); err != nil {
+			return "", err
+		}
 	}
 	for _, expr := range prog.Exprs {
-		obj := eval.Eval(expr, v)
+		obj := eval.Eval(expr, env)
 		if err, ok := object.AsError(obj); ok {
-			return "", err
+			return "", t.describe(err)
 		}
 		fmt.Fprintf(out, "%s", obj)
 	}
 	return out.String(), nil
 }
 
+// describe adds the name of the template and an excerpt of the source to a
+// parse or execution error.
+func (t *template) describe(err error) error {
+	var list errors.ErrorList
+	var parseErr *errors.ParseError
+	var execErr *errors.ExecError
+	switch {
+	case errors.As(err, &list):
+		for _, parseErr := range list {
+			parseErr.Name = t.name
+			parseErr.Excerpt = errors.Excerpt(t.source, parseErr.Span)
+		}
+	case errors.As(err, &parseErr):
+		parseErr.Name = t.name
+		parseErr.Excerpt = errors.Excerpt(t.source, parseErr.Span)
+	case errors.As(err, &execErr):
+		execErr.Name = t.name
+		execErr.Excerpt = errors.Excerpt(t.source, execErr.Span)
+	}
+	return err
+}
+
 // Consumes tokens until it finds }}, which marks the end of an action section.
 // Returns an error if an unexpected token appears, e.g. EOF
 func (t *template) collectActionTokens() ([]token.Token, error) {
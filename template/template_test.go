@@ -1,10 +1,18 @@
 package template_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"os"
+	"strings"
 	"testing"
+	gotemplate "text/template"
 
+	"github.com/kvalv/template-mvp/ast"
+	"github.com/kvalv/template-mvp/errors"
 	"github.com/kvalv/template-mvp/template"
+	"github.com/kvalv/template-mvp/token"
 )
 
 func TestTemplate(t *testing.T) {
@@ -13,6 +21,7 @@ func TestTemplate(t *testing.T) {
 		input string
 		data  any
 		want  string
+		err   error
 		skip  bool
 	}{
 		{
@@ -23,6 +32,40 @@ func TestTemplate(t *testing.T) {
 			}{Name: "World"},
 			want: "Hello World",
 		},
+		{
+			descr: "braces/json",
+			input: `{"name": "{{.Name}}", "tags": {}}`,
+			data:  struct{ Name string }{Name: "World"},
+			want:  `{"name": "World", "tags": {}}`,
+		},
+		{
+			descr: "braces/css",
+			input: "a { color: {{.Name}}; }\nb {}\n}}",
+			data:  struct{ Name string }{Name: "red"},
+			want:  "a { color: red; }\nb {}\n}}",
+		},
+		{
+			descr: "braces/delimiters",
+			input: `{{"{{"}} .Name {{"}}"}} is {{.Name}}`,
+			data:  struct{ Name string }{Name: "World"},
+			want:  "{{ .Name }} is World",
+		},
+		{
+			descr: "identifiers",
+			input: "{{.Line2}} {{.Total_EUR}} {{.Größe}} {{$n_1 := .Line2}}{{$n_1}}",
+			data: struct {
+				Line2     string
+				Total_EUR int
+				Größe     string
+			}{Line2: "b", Total_EUR: 12, Größe: "XL"},
+			want: "b 12 XL b",
+		},
+		{
+			descr: "identifiers/map keys",
+			input: "{{.größe}}: {{.ünits_2}} ✓",
+			data:  map[string]string{"größe": "Größe", "ünits_2": "Stück"},
+			want:  "Größe: Stück ✓",
+		},
 		{
 			descr: "two variables",
 			input: "One {{.Two}} {{.Three}}",
@@ -40,6 +83,513 @@ func TestTemplate(t *testing.T) {
 			}{One: 1},
 			want: "3",
 		},
+		{
+			descr: "arithmetic/precedence",
+			input: "{{.Qty * .UnitPrice / 100}} {{2 + 3 * 4}} {{10 - 4 - 3}} {{17 % 5}}",
+			data: struct {
+				Qty, UnitPrice int
+			}{Qty: 3, UnitPrice: 250},
+			want: "7 14 3 2",
+		},
+		{
+			descr: "arithmetic/parentheses",
+			input: "{{(.A + .B) * 2}} {{2 * (3 + (4 - 1))}}",
+			data: struct {
+				A, B int
+			}{A: 1, B: 2},
+			want: "6 12",
+		},
+		{
+			descr: "arithmetic/division by zero",
+			input: "{{.A / .B}}",
+			data: struct {
+				A, B int
+			}{A: 1},
+			err: errors.ErrDivisionByZero,
+		},
+		{
+			descr: "arithmetic/modulo by zero",
+			input: "{{.A % 0}}",
+			data: struct {
+				A int
+			}{A: 1},
+			err: errors.ErrDivisionByZero,
+		},
+		{
+			descr: "boolean/operators",
+			input: "{{.Admin || (.Owner && !.Locked)}} {{!.Admin}} {{!!.Owner}} {{.Owner && .Locked}}",
+			data: struct {
+				Admin, Owner, Locked bool
+			}{Owner: true},
+			want: "true true true false",
+		},
+		{
+			descr: "boolean/condition",
+			input: "{{if .Admin || (.Owner && !.Locked)}}edit{{else}}view{{end}}",
+			data: struct {
+				Admin, Owner, Locked bool
+			}{Owner: true, Locked: true},
+			want: "view",
+		},
+		{
+			descr: "boolean/truthiness",
+			input: `{{.Name && .Count}} {{"" || 0}} {{!""}}`,
+			data: struct {
+				Name  string
+				Count int
+			}{Name: "x", Count: 2},
+			want: "true false true",
+		},
+		{
+			descr: "boolean/short circuit",
+			input: "{{.Ok || .Missing}} {{.No && .Missing}} {{.Ok || 1 / 0}}",
+			data: struct {
+				Ok, No bool
+			}{Ok: true},
+			want: "true false true",
+		},
+		{
+			descr: "boolean/evaluates rhs when needed",
+			input: "{{.Ok && .Missing}}",
+			data: struct {
+				Ok bool
+			}{Ok: true},
+			err: errors.ErrFieldNotFound,
+		},
+		{
+			descr: "unary/minus",
+			input: "{{-3}} {{-.Balance}} {{- .Balance * 2}} {{-(1 + 2)}} {{+4}} {{--1}}",
+			data: struct {
+				Balance int
+			}{Balance: 10},
+			want: "-3 -10 -20 -3 4 1",
+		},
+		{
+			descr: "unary/signed number after operand",
+			input: "{{.A -1}} {{.A-1}} {{.A - -1}} {{5 +1}}",
+			data: struct {
+				A int
+			}{A: 3},
+			want: "2 2 4 6",
+		},
+		{
+			descr: "unary/negative literal in comparison",
+			input: "{{if .Balance < -100}}overdrawn{{else if .Balance >= -1}}ok{{end}}",
+			data: struct {
+				Balance int
+			}{Balance: -1},
+			want: "ok",
+		},
+		{
+			descr: "numbers/field kinds",
+			input: "{{.Price}} {{.ID}} {{.Timestamp}} {{.Small}}",
+			data: struct {
+				Price     float64
+				ID        uint32
+				Timestamp int64
+				Small     int8
+			}{Price: 9.99, ID: 42, Timestamp: 1700000000000, Small: -3},
+			want: "9.99 42 1700000000000 -3",
+		},
+		{
+			descr: "numbers/promotion",
+			input: "{{.Price * 2}} {{.ID + 1}} {{.ID - -1}} {{.Price + .ID}} {{7 / 2}} {{7 / 2.0}} {{.Timestamp / 1000}}",
+			data: struct {
+				Price     float64
+				ID        uint32
+				Timestamp int64
+			}{Price: 1.25, ID: 42, Timestamp: 1700000000000},
+			want: "2.5 43 43 43.25 3 3.5 1700000000",
+		},
+		{
+			descr: "numbers/literals",
+			input: "{{0x1F}} {{0o17}} {{0b101}} {{1_000_000}} {{1.5e3}} {{2.5E-1}} {{0x1p4}} {{0x1e-3}}",
+			want:  "31 15 5 1000000 1500 0.25 16 27",
+		},
+		{
+			descr: "numbers/compare across types",
+			input: "{{.ID == 42}} {{.Price > 9}} {{.ID > -1}} {{.Big > 1}} {{-1 < .Big}}",
+			data: struct {
+				ID    uint32
+				Price float64
+				Big   uint64
+			}{ID: 42, Price: 9.99, Big: 1 << 63},
+			want: "true true true true true",
+		},
+		{
+			descr: "numbers/int overflow",
+			input: "{{.N + 1}}",
+			data: struct {
+				N int64
+			}{N: math.MaxInt64},
+			err: errors.ErrOverflow,
+		},
+		{
+			descr: "numbers/uint underflow",
+			input: "{{.ID - 43}}",
+			data: struct {
+				ID uint32
+			}{ID: 42},
+			err: errors.ErrOverflow,
+		},
+		{
+			descr: "numbers/multiplication overflow",
+			input: "{{.N * .N}}",
+			data: struct {
+				N int
+			}{N: 1 << 40},
+			err: errors.ErrOverflow,
+		},
+		{
+			descr: "numbers/unsigned literals",
+			input: "{{9223372036854775808}} {{18446744073709551615}} {{0xFFFFFFFFFFFFFFFF - 1}} {{.Big == 9223372036854775808}}",
+			data: struct {
+				Big uint64
+			}{Big: 1 << 63},
+			want: "9223372036854775808 18446744073709551615 18446744073709551614 true",
+		},
+		{
+			descr: "numbers/literal out of range",
+			input: "{{18446744073709551616}} {{-9223372036854775809}}",
+			err:   errors.ErrOverflow,
+		},
+		{
+			descr: "numbers/float division by zero",
+			input: "{{.Price / 0}}",
+			data: struct {
+				Price float64
+			}{Price: 1},
+			err: errors.ErrDivisionByZero,
+		},
+		{
+			descr: "kinds/scalars",
+			input: "{{if .Enabled}}on{{end}} {{.Tiny}} {{.Short}} {{.Rune}} {{.U}} {{.Byte}} {{.Port}} {{.Huge}} {{.Ratio}} {{.Bytes}}",
+			data: struct {
+				Enabled bool
+				Tiny    int8
+				Short   int16
+				Rune    int32
+				U       uint
+				Byte    uint8
+				Port    uint16
+				Huge    uint64
+				Ratio   float32
+				Bytes   []byte
+			}{true, -8, -16, -32, 1, 8, 16, math.MaxUint64, 0.5, []byte("hi")},
+			want: "on -8 -16 -32 1 8 16 18446744073709551615 0.5 hi",
+		},
+		{
+			descr: "kinds/pointers and interfaces",
+			input: "{{.Name}} {{.Count}} {{.Any}} {{with .User}}{{.Name}}{{end}} {{.Any + 1}}",
+			data: &struct {
+				Name  *string
+				Count **int
+				Any   any
+				User  *struct{ Name string }
+			}{Name: ptr("bob"), Count: ptr(ptr(3)), Any: 41, User: &struct{ Name string }{"alice"}},
+			want: "bob 3 41 alice 42",
+		},
+		{
+			descr: "kinds/nil pointers and interfaces",
+			input: "{{.Name}} {{.Any}} {{if .Name}}x{{else}}empty{{end}} {{.Name == nil}} {{range .Items}}x{{else}}none{{end}}",
+			data: struct {
+				Name  *string
+				Any   any
+				Items *[]int
+			}{},
+			want: "<nil> <nil> empty true none",
+		},
+		{
+			descr: "kinds/interface holding a struct",
+			input: "{{with .Any}}{{.Name}}{{end}}",
+			data: struct {
+				Any any
+			}{Any: struct{ Name string }{"carol"}},
+			want: "carol",
+		},
+		{
+			descr: "chain",
+			input: "{{.Order.Customer.Address.City}} {{len .Order.Items}} {{.Order.Total * 2}}",
+			data: struct {
+				Order *order
+			}{Order: &order{Customer: customer{Address: &address{City: "Oslo"}}, Items: []string{"a"}, Total: 5}},
+			want: "Oslo 1 10",
+		},
+		{
+			descr: "chain/on variable and group",
+			input: "{{$o := .Order}}{{$o.Customer.Address.City}} {{(.Order).Total}}",
+			data: struct {
+				Order order
+			}{Order: order{Customer: customer{Address: &address{City: "Oslo"}}, Total: 5}},
+			want: "Oslo 5",
+		},
+		{
+			descr: "chain/field not found",
+			input: "{{.Order.Customer.Adress.City}}",
+			data: struct {
+				Order order
+			}{},
+			err: errors.ErrFieldNotFound,
+		},
+		{
+			descr: "chain/nil pointer",
+			input: "{{.Order.Customer.Address.City}}",
+			data: struct {
+				Order order
+			}{},
+			err: errors.ErrNilPointer,
+		},
+		{
+			descr: "maps/json",
+			input: `{{.user.name}} {{range .items}}{{.id}}:{{.price * 2}} {{end}}{{index . "content-type"}} {{index .user "tags" 1}} {{len .items}}`,
+			data: decodeJSON(`{
+				"user": {"name": "bob", "tags": ["a", "b"]},
+				"items": [{"id": 1, "price": 1.5}, {"id": 2, "price": 3}],
+				"content-type": "text/plain"
+			}`),
+			want: "bob 1:3 2:6 text/plain b 2",
+		},
+		{
+			descr: "maps/struct field",
+			input: "{{.Labels.env}} {{.Counts.open}} {{if .Labels}}labeled{{end}}",
+			data: struct {
+				Labels map[string]string
+				Counts map[label]int
+			}{Labels: map[string]string{"env": "prod"}, Counts: map[label]int{"open": 3}},
+			want: "prod 3 labeled",
+		},
+		{
+			descr: "maps/keyword keys",
+			input: "{{.period.end}} {{.end}} {{.nil}} {{$p := .period}}{{$p.end}}",
+			data: map[string]any{
+				"period": map[string]any{"end": "june"},
+				"end":    1,
+				"nil":    true,
+			},
+			want: "june 1 true june",
+		},
+		{
+			descr: "maps/missing key",
+			input: "{{.user.email}}",
+			data:  decodeJSON(`{"user": {"name": "bob"}}`),
+			err:   errors.ErrKeyNotFound,
+		},
+		{
+			descr: "maps/missing key with index",
+			input: `{{index .user "email"}}`,
+			data:  decodeJSON(`{"user": {"name": "bob"}}`),
+			want:  "<nil>",
+		},
+		{
+			descr: "index/slices, strings and maps",
+			input: `{{.Items[0]}} {{.Items[len .Items - 1]}} {{.Name[0]}} {{.Map["a-b"]}} {{.Map["nope"]}} {{.Grid[1][0]}}`,
+			data: struct {
+				Items []string
+				Name  string
+				Map   map[string]int
+				Grid  [][]int
+			}{Items: []string{"x", "y", "z"}, Name: "hi", Map: map[string]int{"a-b": 7}, Grid: [][]int{{1}, {2}}},
+			want: "x z 104 7 0 2",
+		},
+		{
+			descr: "index/followed by selector",
+			input: "{{.Orders[1].Customer.Address.City}} {{$o := .Orders}}{{$o[0].Total + 1}}",
+			data: struct {
+				Orders []order
+			}{Orders: []order{{Total: 1}, {Customer: customer{Address: &address{City: "Oslo"}}}}},
+			want: "Oslo 2",
+		},
+		{
+			descr: "index/json",
+			input: "{{.items[1].id}} {{.user.tags[0]}}",
+			data:  decodeJSON(`{"items": [{"id": 1}, {"id": 2}], "user": {"tags": ["a"]}}`),
+			want:  "2 a",
+		},
+		{
+			descr: "index/out of range",
+			input: "{{.Items[3]}}",
+			data: struct {
+				Items []int
+			}{Items: []int{1, 2, 3}},
+			err: errors.ErrOutOfRange,
+		},
+		{
+			descr: "index/negative",
+			input: "{{.Items[-1]}}",
+			data: struct {
+				Items []int
+			}{Items: []int{1, 2, 3}},
+			err: errors.ErrOutOfRange,
+		},
+		{
+			descr: "slice",
+			input: "{{.Name[1:4]}} {{.Name[:2]}} {{.Name[3:]}} {{.Name[:]}} {{.Items[1:]}} {{len .Items[:1]}}",
+			data: struct {
+				Name  string
+				Items []int
+			}{Name: "hello", Items: []int{1, 2, 3}},
+			want: "ell he lo hello [2 3] 1",
+		},
+		{
+			// the data is passed by value, so the array isn't addressable
+			descr: "slice/array",
+			input: "{{.Arr[1:]}} {{.Arr[:2]}} {{.Arr[:]}} {{.Nested.Arr[1:2]}}",
+			data: struct {
+				Arr    [3]int
+				Nested struct{ Arr [2]string }
+			}{Arr: [3]int{1, 2, 3}, Nested: struct{ Arr [2]string }{Arr: [2]string{"a", "b"}}},
+			want: "[2 3] [1 2] [1 2 3] [b]",
+		},
+		{
+			descr: "slice/array out of range",
+			input: "{{.Arr[1:4]}}",
+			data: struct {
+				Arr [3]int
+			}{Arr: [3]int{1, 2, 3}},
+			err: errors.ErrOutOfRange,
+		},
+		{
+			// the data is passed by value, so the array isn't addressable
+			descr: "slice/builtin on array",
+			input: "{{slice .Arr 0 2}} {{slice .Arr 1}} {{slice .Arr 0 1 2 | len}}",
+			data: struct {
+				Arr [3]int
+			}{Arr: [3]int{1, 2, 3}},
+			want: "[1 2] [2 3] 1",
+		},
+		{
+			descr: "slice/out of range",
+			input: "{{.Name[2:9]}}",
+			data: struct {
+				Name string
+			}{Name: "hello"},
+			err: errors.ErrOutOfRange,
+		},
+		{
+			descr: "methods/without arguments",
+			input: "{{.FullName}} {{.Person.FullName}} {{.Person.Initials}} {{len .FullName}}",
+			data: &struct {
+				*person
+				Person person
+			}{&person{First: "Ada", Last: "Lovelace"}, person{First: "Alan", Last: "Turing"}},
+			want: "Ada Lovelace Alan Turing AT 12",
+		},
+		{
+			descr: "methods/with arguments",
+			input: `{{.Price.Format "EUR"}} {{.Person.Greet "Hi" 2}} {{"NOK" | .Price.Format}} {{.Price + 1}}`,
+			data: struct {
+				Price  money
+				Person *person
+			}{Price: 1250, Person: &person{First: "Ada"}},
+			want: "12.50 EUR Hi Ada! Hi Ada! 12.50 NOK 1251",
+		},
+		{
+			descr: "methods/named type in with and variables",
+			input: `{{with .Price}}{{.Format "EUR"}}{{end}} {{$p := .Price}}{{$p.Format "EUR"}} {{$p = .Total}}{{$p.Format "NOK"}} {{with $t := .Tag}}{{$t.Upper}}{{end}}`,
+			data: struct {
+				Price, Total money
+				Tag          label
+			}{Price: 1250, Total: 99, Tag: "new"},
+			want: "12.50 EUR 12.50 EUR 0.99 NOK NEW",
+		},
+		{
+			descr: "methods/in range",
+			input: `{{range .People}}{{.Initials}} {{end}}`,
+			data: struct {
+				People []person
+			}{People: []person{{First: "Ada", Last: "Lovelace"}, {First: "Alan", Last: "Turing"}}},
+			want: "AL AT ",
+		},
+		{
+			descr: "methods/error return",
+			input: `{{.Price.Format "XXX"}}`,
+			data: struct {
+				Price money
+			}{Price: 1},
+			err: errUnknownCurrency,
+		},
+		{
+			descr: "methods/not found",
+			input: `{{.Person.Nope "x"}}`,
+			data: struct {
+				Person person
+			}{},
+			err: errors.ErrMethodNotFound,
+		},
+		{
+			descr: "compare/numbers",
+			input: "{{.A != 2}} {{.A <= 2}} {{.A >= 2}} {{.A < 2}} {{.A > 1}} {{.A == 1}}",
+			data: struct {
+				A int
+			}{A: 2},
+			want: "false true true false true false",
+		},
+		{
+			// NaN is neither less than, equal to nor greater than anything
+			descr: "compare/NaN",
+			input: "{{.NaN <= 1}} {{.NaN >= 1}} {{1 <= .NaN}} {{.NaN < 1}} {{.NaN > 1}} {{.NaN == .NaN}} {{.NaN != .NaN}} {{le .NaN 1.0}} {{ge .NaN 1.0}} {{le 1.0 .NaN}}",
+			data: struct {
+				NaN float64
+			}{NaN: math.NaN()},
+			want: "false false false false false false true false false false",
+		},
+		{
+			descr: "compare/strings",
+			input: `{{if .Name == "bob"}}hi bob{{end}} {{"apple" < "banana"}} {{.Name >= "bobby"}} {{"b" > "B"}}`,
+			data: struct {
+				Name string
+			}{Name: "bob"},
+			want: "hi bob true false true",
+		},
+		{
+			descr: "compare/bools",
+			input: "{{.Ok == true}} {{.Ok != .No}} {{false == .No}}",
+			data: struct {
+				Ok, No bool
+			}{Ok: true},
+			want: "true true true",
+		},
+		{
+			descr: "compare/nil",
+			input: "{{.P == nil}} {{.Items != nil}} {{nil == .M}} {{nil == nil}}",
+			data: struct {
+				P     *int
+				Items []int
+				M     map[string]int
+			}{Items: []int{1}},
+			want: "true true true true",
+		},
+		{
+			descr: "compare/number and string",
+			input: `{{.A == "1"}}`,
+			data: struct {
+				A int
+			}{A: 1},
+			err: errors.ErrIncompatibleTypes,
+		},
+		{
+			descr: "compare/bools have no order",
+			input: "{{true < false}}",
+			err:   errors.ErrNotOrdered,
+		},
+		{
+			descr: "compare/nil and number",
+			input: "{{.A != nil}}",
+			data: struct {
+				A int
+			}{A: 1},
+			want: "true",
+		},
+		{
+			descr: "compare/non-nil pointer and interface",
+			input: `{{.P == nil}} {{.S == nil}} {{eq .P nil}} {{.I != nil}} {{if .P != nil && .P.Name}}{{.P.Name}}{{end}}`,
+			data: struct {
+				P *user
+				S *string
+				I any
+			}{P: &user{Name: "ann"}, S: new(string), I: user{}},
+			want: "false false false true ann",
+		},
 		{
 			descr: "cond/true",
 			input: "{{if true}}hi{{end}}",
@@ -100,11 +650,214 @@ func TestTemplate(t *testing.T) {
 				field int
 			}{field: 0},
 		},
+		{
+			descr: "cond/else",
+			input: "{{if .Admin}}admin{{else}}user{{end}}",
+			want:  "user",
+			data: struct {
+				Admin int
+			}{Admin: 0},
+		},
+		{
+			descr: "cond/else if",
+			input: "{{if .N > 10}}big{{else if .N > 5}}medium{{else if .N > 0}}small{{else}}none{{end}}",
+			want:  "medium",
+			data: struct {
+				N int
+			}{N: 7},
+		},
+		{
+			descr: "cond/else if/fallthrough",
+			input: "{{if .N > 10}}big{{else if .N > 5}}medium{{else}}none{{end}}",
+			want:  "none",
+			data: struct {
+				N int
+			}{N: 0},
+		},
+		{
+			descr: "cond/else if/no else",
+			input: "{{if .N > 10}}big{{else if .N > 5}}medium{{end}}",
+			want:  "",
+			data: struct {
+				N int
+			}{N: 0},
+		},
 		{
 			descr: "nested",
 			input: "{{if 2 > 1}}{{if 1 > 0}}hi{{end}}{{end}}",
 			want:  "hi",
 		},
+		{
+			descr: "cond/body with text and actions",
+			input: "{{if .X}}Hello {{.Name}}!{{end}}",
+			want:  "Hello World!",
+			data: struct {
+				X    int
+				Name string
+			}{X: 1, Name: "World"},
+		},
+		{
+			descr: "cond/empty body",
+			input: "a{{if 1}}{{end}}b",
+			want:  "ab",
+		},
+		{
+			descr: "many expressions",
+			input: "{{1}} {{2}} {{3}} {{4}} {{5}} {{6}} {{7}} {{8}}",
+			want:  "1 2 3 4 5 6 7 8",
+		},
+		{
+			descr: "nested blocks",
+			input: "{{range .Rows}}[{{if .Show}}{{range .Cells}}<{{.}}>{{else}}empty{{end}}{{else}}hidden{{end}}]{{end}}",
+			data: struct {
+				Rows []struct {
+					Show  int
+					Cells []int
+				}
+			}{
+				Rows: []struct {
+					Show  int
+					Cells []int
+				}{
+					{Show: 1, Cells: []int{1, 2}},
+					{Show: 0, Cells: []int{3}},
+					{Show: 1},
+				},
+			},
+			want: "[<1><2>][hidden][empty]",
+		},
+		{
+			descr: "with",
+			input: "{{with .Customer}}{{.Name}} {{.Email}}{{else}}no customer{{end}}",
+			data: struct {
+				Customer struct {
+					Name  string
+					Email string
+				}
+			}{Customer: struct {
+				Name  string
+				Email string
+			}{Name: "Alice", Email: "alice@example.com"}},
+			want: "Alice alice@example.com",
+		},
+		{
+			descr: "with/else",
+			input: "{{with .Email}}{{.}}{{else}}no email{{end}}",
+			data: struct {
+				Email string
+			}{},
+			want: "no email",
+		},
+		{
+			descr: "with/nested",
+			input: "{{with .A}}{{with .B}}{{.C}}{{end}}{{end}}",
+			data: struct {
+				A struct{ B struct{ C int } }
+			}{A: struct{ B struct{ C int } }{B: struct{ C int }{C: 3}}},
+			want: "3",
+		},
+		{
+			descr: "variable",
+			input: "{{$x := .N + 1}}{{$x}} {{$x = $x + 1}}{{$x}}",
+			data: struct {
+				N int
+			}{N: 1},
+			want: "2 3",
+		},
+		{
+			descr: "variable/root",
+			input: "{{with .A}}{{.B}} {{$.C}}{{end}}",
+			data: struct {
+				A struct{ B string }
+				C string
+			}{A: struct{ B string }{B: "b"}, C: "c"},
+			want: "b c",
+		},
+		{
+			descr: "variable/root in range",
+			input: "{{if true}}{{range $e := $}}{{$e}}{{$}}{{end}}{{end}}",
+			data:  []string{"a", "b"},
+			want:  "a[a b]b[a b]",
+		},
+		{
+			descr: "variable/assign in block",
+			input: "{{$x := 1}}{{if true}}{{$x = 2}}{{end}}{{$x}}",
+			want:  "2",
+		},
+		{
+			descr: "variable/shadow in block",
+			input: "{{$x := 1}}{{if true}}{{$x := 2}}{{$x}}{{end}}{{$x}}",
+			want:  "21",
+		},
+		{
+			descr: "variable/declared in if",
+			input: "{{if $x := .N}}{{$x}}{{else}}{{$x}} is falsy{{end}}",
+			data: struct {
+				N int
+			}{N: 0},
+			want: "0 is falsy",
+		},
+		{
+			descr: "variable/with",
+			input: "{{with $c := .C}}{{$c}}{{.}}{{end}}",
+			data: struct {
+				C string
+			}{C: "c"},
+			want: "cc",
+		},
+		{
+			descr: "variable/range",
+			input: "{{range $i, $e := .Items}}{{$i}}={{$e}} {{end}}",
+			data: struct {
+				Items []string
+			}{Items: []string{"a", "b"}},
+			want: "0=a 1=b ",
+		},
+		{
+			descr: "variable/range value",
+			input: "{{range $e := .Items}}{{$e}}{{end}}",
+			data: struct {
+				Items []int
+			}{Items: []int{1, 2}},
+			want: "12",
+		},
+		{
+			descr: "variable/out of scope",
+			input: "{{if true}}{{$x := 1}}{{end}}{{$x}}",
+			err:   errors.ErrUndefinedVar,
+		},
+		{
+			descr: "variable/assign undeclared",
+			input: "{{$x = 1}}",
+			err:   errors.ErrUndefinedVar,
+		},
+		{
+			descr: "string",
+			input: `{{"Hello, "}}{{.Name}}`,
+			data:  struct{ Name string }{Name: "World"},
+			want:  "Hello, World",
+		},
+		{
+			descr: "string/escapes",
+			input: `{{"tab:\t quote:\" unicode:\u00e9 }}"}}`,
+			want:  "tab:\t quote:\" unicode:\u00e9 }}",
+		},
+		{
+			descr: "string/raw",
+			input: "{{`C:\\path\nwith }} newline`}}",
+			want:  "C:\\path\nwith }} newline",
+		},
+		{
+			descr: "string/concat",
+			input: `{{.Name + "!"}}`,
+			data:  struct{ Name string }{Name: "hi"},
+			want:  "hi!",
+		},
+		{
+			descr: "char",
+			input: `{{'a'}} {{'\n'}}`,
+			want:  "97 10",
+		},
 		{
 			descr: "dot",
 			input: "{{.}}",
@@ -126,18 +879,69 @@ func TestTemplate(t *testing.T) {
 					{Name: "Bob"},
 				},
 			},
-			skip: true,
 			want: "Name: Alice - Name: Bob - ",
 		},
+		{
+			descr: "range/dot",
+			input: "{{range .Items}}{{.}}{{end}}",
+			data: struct {
+				Items [3]int
+			}{Items: [3]int{1, 2, 3}},
+			want: "123",
+		},
+		{
+			descr: "range/map",
+			input: "{{range .Prices}}{{.}}{{end}}",
+			data: struct {
+				Prices map[string]int
+			}{Prices: map[string]int{"c": 3, "a": 1, "b": 2}},
+			want: "123",
+		},
+		{
+			descr: "range/bytes",
+			input: "{{.B}}: {{range $i, $b := .B}}{{$i}}={{$b}} {{end}}",
+			data: struct {
+				B []byte
+			}{B: []byte("hi")},
+			want: "hi: 0=104 1=105 ",
+		},
+		{
+			descr: "range/chan",
+			input: "{{range .Ch}}{{.}}{{end}}",
+			data: struct {
+				Ch chan string
+			}{Ch: func() chan string {
+				ch := make(chan string, 2)
+				ch <- "x"
+				ch <- "y"
+				close(ch)
+				return ch
+			}()},
+			want: "xy",
+		},
+		{
+			descr: "range/else",
+			input: "{{range .Items}}{{.}}{{else}}none{{end}}",
+			data: struct {
+				Items []string
+			}{},
+			want: "none",
+		},
 	}
 
 	for _, tc := range cases {
-		if tc.skip {
-			t.Skip(tc.descr)
-		}
 		t.Run(tc.descr, func(t *testing.T) {
+			if tc.skip {
+				t.Skip(tc.descr)
+			}
 			templ := template.New(tc.input, template.LogDest(os.Stderr))
 			got, err := templ.Execute(tc.data)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("error mismatch; want=%q, got=%v", tc.err, err)
+				}
+				return
+			}
 			if err != nil {
 				t.Fatalf("Parse error: %s", err)
 			}
@@ -147,3 +951,627 @@ func TestTemplate(t *testing.T) {
 		})
 	}
 }
+
+type (
+	status string
+	tags   []string
+)
+
+type label string
+
+func (l label) Upper() string { return strings.ToUpper(string(l)) }
+
+var errUnknownCurrency = errors.New("unknown currency")
+
+type money int
+
+func (m money) Format(currency string) (string, error) {
+	if currency == "XXX" {
+		return "", errUnknownCurrency
+	}
+	return fmt.Sprintf("%d.%02d %s", m/100, m%100, currency), nil
+}
+
+type person struct {
+	First, Last string
+}
+
+func (p person) FullName() string {
+	return p.First + " " + p.Last
+}
+
+func (p *person) Initials() string {
+	return p.First[:1] + p.Last[:1]
+}
+
+func (p *person) Greet(greeting string, times int) string {
+	return strings.Repeat(greeting+" "+p.First+"! ", times-1) + greeting + " " + p.First + "!"
+}
+
+func decodeJSON(s string) any {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+type user struct {
+	Name string
+}
+
+type (
+	order struct {
+		Customer customer
+		Items    []string
+		Total    int
+	}
+	customer struct {
+		Address *address
+	}
+	address struct {
+		City string
+	}
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestFuncs(t *testing.T) {
+	errTooLong := errors.New("too long")
+	funcs := template.FuncMap{
+		"upper": strings.ToUpper,
+		"truncate": func(n int, s string) string {
+			if len(s) <= n {
+				return s
+			}
+			return s[:n]
+		},
+		"join": func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		},
+		"check": func(s string) (string, error) {
+			if len(s) > 3 {
+				return "", errTooLong
+			}
+			return s, nil
+		},
+		"answer": func() int { return 42 },
+		"add":    func(a, b int) int { return a + b },
+		"byte":   func(b byte) byte { return b },
+		"name":   func(u *user) string { return u.Name },
+		"show":   func(s status) string { return "status: " + string(s) },
+		"size":   func(b []byte) int { return len(b) },
+		"tags":   func(t tags) string { return strings.Join(t, ",") },
+	}
+
+	cases := []struct {
+		descr string
+		input string
+		data  any
+		want  string
+		err   error
+	}{
+		{
+			descr: "call",
+			input: "{{upper .Title}}",
+			data:  struct{ Title string }{Title: "hello"},
+			want:  "HELLO",
+		},
+		{
+			descr: "no arguments",
+			input: "{{answer}}",
+			want:  "42",
+		},
+		{
+			descr: "pipeline",
+			input: "{{.Title | upper | truncate 3}}",
+			data:  struct{ Title string }{Title: "hello"},
+			want:  "HEL",
+		},
+		{
+			descr: "pipeline/variable",
+			input: "{{$t := .Title | upper}}{{$t}}",
+			data:  struct{ Title string }{Title: "hi"},
+			want:  "HI",
+		},
+		{
+			descr: "pipeline/in condition",
+			input: "{{if .Title | truncate 0}}yes{{else}}no{{end}}",
+			data:  struct{ Title string }{Title: "hi"},
+			want:  "no",
+		},
+		{
+			descr: "call/binds tighter than infix",
+			input: "{{answer + 1}}",
+			want:  "43",
+		},
+		{
+			descr: "pointer argument",
+			input: "{{name .User}}",
+			data:  struct{ User *user }{User: &user{Name: "bob"}},
+			want:  "bob",
+		},
+		{
+			descr: "named type argument",
+			input: "{{show .S}} {{.S | show}} {{show \"new\"}}",
+			data:  struct{ S status }{S: "paid"},
+			want:  "status: paid status: paid status: new",
+		},
+		{
+			descr: "named slice argument",
+			input: "{{tags .T}}",
+			data:  struct{ T []string }{T: []string{"a", "b"}},
+			want:  "a,b",
+		},
+		{
+			descr: "byte slice argument",
+			input: "{{size .B}} {{.S | size}} {{size \"héllo\"}}",
+			data: struct {
+				B []byte
+				S string
+			}{B: []byte("abc"), S: "ab"},
+			want: "3 2 6",
+		},
+		{
+			descr: "argument out of range",
+			input: "{{byte .N}}",
+			data:  struct{ N int }{N: 256},
+			err:   errors.ErrOverflow,
+		},
+		{
+			descr: "negative argument",
+			input: "{{add .A -1}} {{add -2 -3}}",
+			data:  struct{ A int }{A: 5},
+			want:  "4 -5",
+		},
+		{
+			descr: "variadic",
+			input: "{{join .Sep .A .B .C}}",
+			data: struct {
+				Sep     string
+				A, B, C string
+			}{Sep: "-", A: "a", B: "b", C: "c"},
+			want: "a-b-c",
+		},
+		{
+			descr: "error return",
+			input: "{{check .Title}}",
+			data:  struct{ Title string }{Title: "hello"},
+			err:   errTooLong,
+		},
+		{
+			descr: "undefined",
+			input: "{{nope .Title}}",
+			data:  struct{ Title string }{Title: "hello"},
+			err:   errors.ErrUndefinedFunc,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			templ := template.New(tc.input, template.LogDest(os.Stderr), template.Funcs(funcs))
+			got, err := templ.Execute(tc.data)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("error mismatch; want=%q, got=%v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute error: %s", err)
+			}
+			if tc.want != got {
+				t.Fatalf("Result mismatch; want=%q, got=%q", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("not a function", func(t *testing.T) {
+		templ := template.New("{{x}}", template.Funcs(template.FuncMap{"x": 1}))
+		if _, err := templ.Execute(nil); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
+
+// TestBuiltins checks that the builtin functions render the same output as in
+// text/template.
+func TestMissingKey(t *testing.T) {
+	data := struct {
+		Person person
+		M      map[string]int
+		JSON   any
+	}{
+		Person: person{First: "Ada"},
+		M:      map[string]int{"a": 1},
+		JSON:   decodeJSON(`{"user": {"name": "bob"}}`),
+	}
+	input := "{{.Person.Email}}|{{.M.b}}|{{.JSON.user.email}}|{{.JSON.account.id}}|{{.Person.Greeting \"hi\"}}"
+
+	cases := []struct {
+		descr  string
+		policy template.MissingKeyPolicy
+		data   any
+		input  string
+		want   string
+		err    error
+	}{
+		{
+			descr:  "error",
+			policy: template.MissingKeyError,
+			input:  "{{.Person.Email}}",
+			err:    errors.ErrFieldNotFound,
+		},
+		{
+			descr:  "error/map",
+			policy: template.MissingKeyError,
+			input:  "{{.M.b}}",
+			err:    errors.ErrKeyNotFound,
+		},
+		{
+			descr:  "error/method",
+			policy: template.MissingKeyError,
+			input:  `{{.Person.Greeting "hi"}}`,
+			err:    errors.ErrMethodNotFound,
+		},
+		{
+			descr:  "zero",
+			policy: template.MissingKeyZero,
+			input:  input,
+			want:   "<nil>|0|<nil>|<nil>|<nil>",
+		},
+		{
+			descr:  "zero/in condition",
+			policy: template.MissingKeyZero,
+			input:  "{{if .M.b}}yes{{else}}no{{end}} {{.M.b + 1}}",
+			want:   "no 1",
+		},
+		{
+			descr:  "default",
+			policy: template.MissingKeyDefault("n/a"),
+			input:  input,
+			want:   "n/a|n/a|n/a|n/a|n/a",
+		},
+		{
+			descr:  "default/nil data",
+			policy: template.MissingKeyDefault("n/a"),
+			data:   (*person)(nil),
+			input:  "{{.First}}",
+			want:   "n/a",
+		},
+		{
+			descr:  "default/nil dot",
+			policy: template.MissingKeyDefault("n/a"),
+			data:   (*person)(nil),
+			input:  "{{.}} {{.First}}",
+			want:   "n/a n/a",
+		},
+		{
+			descr:  "zero/nil dot",
+			policy: template.MissingKeyZero,
+			data:   (*person)(nil),
+			input:  "{{.}} {{.First}}",
+			want:   "<nil> <nil>",
+		},
+		{
+			descr:  "error/nil dot",
+			policy: template.MissingKeyError,
+			data:   (*person)(nil),
+			input:  "{{.}}",
+			err:    errors.ErrNilData,
+		},
+		{
+			descr:  "default/nil root",
+			policy: template.MissingKeyDefault("n/a"),
+			data:   (*person)(nil),
+			input:  "{{$}} {{$.First}}",
+			want:   "n/a n/a",
+		},
+		{
+			descr:  "error/nil root",
+			policy: template.MissingKeyError,
+			data:   (*person)(nil),
+			input:  "{{$.First}}",
+			err:    errors.ErrNilData,
+		},
+		{
+			// the field exists, it just can't be called
+			descr:  "zero/field with arguments",
+			policy: template.MissingKeyZero,
+			input:  `{{.Person.First "x"}}`,
+			err:    errors.ErrNotCallable,
+		},
+		{
+			descr:  "default/key with arguments",
+			policy: template.MissingKeyDefault("n/a"),
+			input:  `{{.M.a "x"}}`,
+			err:    errors.ErrNotCallable,
+		},
+		{
+			descr:  "default/existing values",
+			policy: template.MissingKeyDefault("n/a"),
+			input:  "{{.Person.First}} {{.M.a}} {{.JSON.user.name}}",
+			want:   "Ada 1 bob",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			var in any = data
+			if tc.data != nil {
+				in = tc.data
+			}
+			templ := template.New(tc.input, template.LogDest(os.Stderr), template.MissingKey(tc.policy))
+			got, err := templ.Execute(in)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("error mismatch; want=%q, got=%v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute error: %s", err)
+			}
+			if got != tc.want {
+				t.Fatalf("Result mismatch; want=%q, got=%q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	data := struct {
+		Name  string
+		Total int
+		Items []int
+	}{Name: "Ada", Total: 42}
+
+	cases := []struct {
+		descr string
+		name  string
+		input string
+		want  string
+	}{
+		{
+			descr: "field",
+			name:  "invoice.tmpl",
+			input: "Total: {{.Totl}}",
+			want:  "invoice.tmpl:1:11: field not found: Totl",
+		},
+		{
+			descr: "unnamed",
+			input: "{{.Totl}}",
+			want:  "1:4: field not found: Totl",
+		},
+		{
+			// columns count runes, not bytes
+			descr: "lines",
+			name:  "invoice.tmpl",
+			input: "Grüße\n\n  {{.Name}} {{.Totl}}",
+			want:  "invoice.tmpl:3:16: field not found: Totl",
+		},
+		{
+			descr: "operator",
+			input: "{{.Name}}\n{{.Name * 2}}",
+			want:  "2:9: unsupported types for *: STRING and NUMBER",
+		},
+		{
+			descr: "index",
+			input: "{{.Items}}\n{{index .Items 3}}",
+			want:  "2:3: error calling index: index out of range: 3",
+		},
+		{
+			descr: "unexpected character",
+			input: "line 1\nline 2\n{{.Total + 1 @}}",
+			want:  "3:14: unexpected character '@'",
+		},
+		{
+			descr: "unterminated action",
+			input: "abcd{{.Name",
+			want:  "1:12: unterminated action starting at 1:5",
+		},
+		{
+			descr: "parse",
+			name:  "invoice.tmpl",
+			input: "{{.Name}}\n{{(.Total + 1}}",
+			want:  `invoice.tmpl:2:14: unexpected token: expected ")", got "ACTIONEND" (missing closing parenthesis?)`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			templ := template.New(tc.input, template.LogDest(os.Stderr), template.Name(tc.name))
+			_, err := templ.Execute(data)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if err.Error() != tc.want {
+				t.Fatalf("message mismatch; want=%q, got=%q", tc.want, err.Error())
+			}
+		})
+	}
+}
+
+func TestErrorTypes(t *testing.T) {
+	data := struct{ Total int }{Total: 42}
+
+	t.Run("exec", func(t *testing.T) {
+		input := "Invoice\n\tTotal: {{.Totl}}"
+		_, err := template.New(input, template.Name("invoice.tmpl")).Execute(data)
+		var execErr *errors.ExecError
+		if !errors.As(err, &execErr) {
+			t.Fatalf("expected an ExecError, got %T: %v", err, err)
+		}
+		if !errors.Is(err, errors.ErrFieldNotFound) {
+			t.Fatalf("expected the error to wrap ErrFieldNotFound")
+		}
+		if execErr.Name != "invoice.tmpl" {
+			t.Fatalf("name mismatch; got=%q", execErr.Name)
+		}
+		if want := (token.Position{Row: 2, Col: 12}); execErr.Span.Start != want {
+			t.Fatalf("position mismatch; want=%s, got=%s", want, execErr.Span.Start)
+		}
+		if want := "\tTotal: {{.Totl}}\n\t          ^"; execErr.Excerpt != want {
+			t.Fatalf("excerpt mismatch; want=%q, got=%q", want, execErr.Excerpt)
+		}
+		if field, ok := execErr.Node.(*ast.Field); !ok || field.Name != "Totl" {
+			t.Fatalf("node mismatch; got=%#v", execErr.Node)
+		}
+	})
+
+	t.Run("nil root", func(t *testing.T) {
+		_, err := template.New("{{$}}", template.Name("invoice.tmpl")).Execute(nil)
+		var execErr *errors.ExecError
+		if !errors.As(err, &execErr) {
+			t.Fatalf("expected an ExecError, got %T: %v", err, err)
+		}
+		if want := "invoice.tmpl:1:3: data is nil: $"; err.Error() != want {
+			t.Fatalf("message mismatch; want=%q, got=%q", want, err.Error())
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		input := "{{$x := 1}}{{.Total := 2}}"
+		_, err := template.New(input).Execute(data)
+		var parseErr *errors.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected a ParseError, got %T: %v", err, err)
+		}
+		if want := (token.Position{Row: 1, Col: 21}); parseErr.Span.Start != want {
+			t.Fatalf("position mismatch; want=%s, got=%s", want, parseErr.Span.Start)
+		}
+		if want := input + "\n" + strings.Repeat(" ", 20) + "^"; parseErr.Excerpt != want {
+			t.Fatalf("excerpt mismatch; want=%q, got=%q", want, parseErr.Excerpt)
+		}
+		if parseErr.Node == nil || parseErr.Node.String() != "(.Total)" {
+			t.Fatalf("node mismatch; got=%v", parseErr.Node)
+		}
+	})
+
+	t.Run("all syntax errors", func(t *testing.T) {
+		input := "{{.Total +}}\n{{if (.Total}}x{{end}}\n{{.Total}}"
+		_, err := template.New(input, template.Name("invoice.tmpl")).Execute(data)
+		var list errors.ErrorList
+		if !errors.As(err, &list) {
+			t.Fatalf("expected an ErrorList, got %T: %v", err, err)
+		}
+		if want := `invoice.tmpl:1:11: unexpected token "ACTIONEND" (and 1 more errors)`; err.Error() != want {
+			t.Fatalf("message mismatch; want=%q, got=%q", want, err.Error())
+		}
+		if len(list) != 2 {
+			t.Fatalf("expected 2 errors, got %d", len(list))
+		}
+		if want := "invoice.tmpl:2:13: unexpected token: expected \")\", got \"ACTIONEND\" (missing closing parenthesis?)"; list[1].Error() != want {
+			t.Fatalf("message mismatch; want=%q, got=%q", want, list[1].Error())
+		}
+		if want := "{{if (.Total}}x{{end}}\n            ^"; list[1].Excerpt != want {
+			t.Fatalf("excerpt mismatch; want=%q, got=%q", want, list[1].Excerpt)
+		}
+	})
+
+	t.Run("unexpected token", func(t *testing.T) {
+		_, err := template.New("{{(1 + 2}}").Execute(data)
+		var parseErr *errors.ParseError
+		if !errors.As(err, &parseErr) || !errors.Is(err, errors.ErrUnexpectedToken) {
+			t.Fatalf("expected a ParseError wrapping ErrUnexpectedToken, got %T: %v", err, err)
+		}
+	})
+}
+
+func TestBuiltins(t *testing.T) {
+	data := struct {
+		Zero, One, Two int
+		S, Key, Format string
+		HTML           string
+		Yes, No        bool
+		Items, Empty   []int
+		M              map[string]int
+		Fn             func(int) int
+		Price          float64
+		ID             uint32
+		NaN            float64
+	}{
+		One:    1,
+		Two:    2,
+		S:      "hello",
+		Key:    "b",
+		Format: "%d-%s",
+		HTML:   `<a href='x?a=1&b=2'>"hi"</a>`,
+		Yes:    true,
+		Items:  []int{1, 2, 3},
+		M:      map[string]int{"a": 1, "b": 2},
+		Fn:     func(i int) int { return i * 10 },
+		Price:  1.5,
+		ID:     7,
+		NaN:    math.NaN(),
+	}
+
+	cases := []struct {
+		descr string
+		input string
+		err   bool
+	}{
+		{descr: "and", input: "{{and .One .Two}} {{and .Zero .Two}} {{and .One}}"},
+		{descr: "or", input: "{{or .Zero .Two}} {{or .Zero .Empty}} {{or .One .Zero}}"},
+		{descr: "and/short circuit", input: "{{and .Zero .Missing}} {{or .One .Missing}}"},
+		{descr: "and/pipeline", input: "{{.Zero | and .One}} {{.Two | or .Zero}}"},
+		{descr: "not", input: "{{not .Zero}} {{not .S}} {{not .Empty}}"},
+		{descr: "len", input: "{{len .Items}} {{len .S}} {{len .M}} {{.Empty | len}}"},
+		{descr: "len/invalid", input: "{{len .One}}", err: true},
+		{descr: "index", input: "{{index .Items 1}} {{index .M .Key}} {{index .M .S}}"},
+		{descr: "index/out of range", input: "{{index .Items 3}}", err: true},
+		{descr: "slice", input: "{{slice .Items 1 2}} {{slice .Items 1}} {{slice .Items}} {{slice .S 1 3}}"},
+		{descr: "slice/invalid", input: "{{slice .Items 2 1}}", err: true},
+		{descr: "print", input: "{{print .One .Two}} {{print .S .S}} {{print .One .S .Items}}"},
+		{descr: "println", input: "{{println .One .S}}"},
+		{descr: "printf", input: "{{printf .Format .One .S}}"},
+		{descr: "printf/literal", input: `{{printf "%s has %d {{items}}" .S .Two}} {{printf "%c" 'x'}}`},
+		{descr: "index/literal", input: `{{index .M "a"}} {{index .Items 0}}`},
+		{descr: "eq/literal", input: `{{eq .S "hello"}} {{eq .S "bye" "hello"}}`},
+		{descr: "eq", input: "{{eq .One .One}} {{eq .One .Two}} {{eq .Two .Zero .One .Two}} {{eq .S .S}} {{eq .Yes .No}}"},
+		{descr: "eq/incompatible", input: "{{eq .One .S}}", err: true},
+		{descr: "ne", input: "{{ne .One .Two}} {{ne .S .S}}"},
+		{descr: "lt", input: "{{lt .One .Two}} {{lt .Two .One}} {{lt .S .Key}}"},
+		{descr: "le", input: "{{le .One .One}} {{le .Two .One}}"},
+		{descr: "le/NaN", input: "{{le .NaN .Price}} {{le .Price .NaN}} {{lt .NaN .Price}} {{eq .NaN .NaN}}"},
+		{descr: "gt", input: "{{gt .One .Two}} {{gt .Two .One}}"},
+		{descr: "ge", input: "{{ge .One .One}} {{ge .One .Two}}"},
+		{descr: "lt/bool", input: "{{lt .Yes .No}}", err: true},
+		{descr: "html", input: "{{html .HTML}}"},
+		{descr: "js", input: "{{js .HTML}}"},
+		{descr: "urlquery", input: "{{urlquery .HTML}}"},
+		{descr: "call", input: "{{call .Fn .Two}}"},
+		{descr: "call/not a function", input: "{{call .One}}", err: true},
+		{descr: "number literals", input: "{{print 1.5 0x1F 1_000 0b101 0o17 017 1e3 2.5e-1}}"},
+		{descr: "eq/mixed numbers", input: "{{eq .Price 1.5}} {{lt .ID 10}}"},
+		{descr: "eq/int and uint", input: "{{eq .ID 7}} {{ne .One .ID}} {{ge .ID .Two}}"},
+		{descr: "eq/int and float", input: "{{eq 1 1.5}}", err: true},
+		{descr: "lt/int and float", input: "{{lt 1 2.5}}", err: true},
+		{descr: "le/float and int", input: "{{le .Price .One}}", err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			var want strings.Builder
+			wantErr := gotemplate.Must(gotemplate.New("").Parse(tc.input)).Execute(&want, data)
+			got, err := template.New(tc.input, template.LogDest(os.Stderr)).Execute(data)
+			if tc.err {
+				if err == nil || wantErr == nil {
+					t.Fatalf("expected errors; got=%v, text/template=%v", err, wantErr)
+				}
+				return
+			}
+			if wantErr != nil {
+				t.Fatalf("text/template error: %s", wantErr)
+			}
+			if err != nil {
+				t.Fatalf("Execute error: %s", err)
+			}
+			if want.String() != got {
+				t.Fatalf("Result mismatch; want=%q, got=%q", want.String(), got)
+			}
+		})
+	}
+}
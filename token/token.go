@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 const (
@@ -9,18 +11,43 @@ const (
 	ACTIONSTART TokenType = "ACTIONSTART"
 	ACTIONEND   TokenType = "ACTIONEND"
 	DOT         TokenType = "DOT"
+	SELECTOR    TokenType = "SELECTOR"
 	IDENT       TokenType = "IDENT"
+	VARIABLE    TokenType = "VARIABLE"
 	NUMBER      TokenType = "NUMBER"
+	STRING      TokenType = "STRING"
+	CHAR        TokenType = "CHAR"
 	PLUS        TokenType = "PLUS"
 	MINUS       TokenType = "MINUS"
+	ASTERISK    TokenType = "*"
+	SLASH       TokenType = "/"
+	PERCENT     TokenType = "%"
+	LPAREN      TokenType = "("
+	RPAREN      TokenType = ")"
+	LBRACKET    TokenType = "["
+	RBRACKET    TokenType = "]"
+	COLON       TokenType = ":"
 	IF          TokenType = "IF"
+	ELSE        TokenType = "ELSE"
 	END         TokenType = "END"
 	TRUE        TokenType = "TRUE"
 	FALSE       TokenType = "FALSE"
+	NIL         TokenType = "NIL"
 	GT          TokenType = ">"
 	LT          TokenType = "<"
 	EQ          TokenType = "=="
+	NOT_EQ      TokenType = "!="
+	LT_EQ       TokenType = "<="
+	GT_EQ       TokenType = ">="
+	AND         TokenType = "&&"
+	OR          TokenType = "||"
+	BANG        TokenType = "!"
+	DECLARE     TokenType = ":="
+	ASSIGN      TokenType = "="
+	COMMA       TokenType = ","
+	PIPE        TokenType = "|"
 	RANGE       TokenType = "RANGE"
+	WITH        TokenType = "WITH"
 )
 
 type Token struct {
@@ -29,9 +56,18 @@ type Token struct {
 	Span
 }
 
+// Pos returns where the token is in the source. The nodes of the AST embed
+// the token they start with, or of their operator, so this is where they are
+// as well.
+func (t Token) Pos() Span { return t.Span }
+
 type Span struct {
 	Start, End Position
 }
 type Position struct {
 	Row, Col int
 }
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Row, p.Col)
+}